@@ -0,0 +1,93 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The limits of the bind parameters of a single query by the drivers.
+var paramLimits = map[string]int{
+	"postgres":  65535,
+	"mysql":     65535,
+	"sqlite":    32766,
+	"sqlserver": 2100,
+}
+
+// The function returns the number of the model rows inserted by one
+// query from DB_BATCH_SIZE, 100 by default. The size is lowered to fit
+// the bind parameters of all model columns within the limit of the
+// connection driver, so the larger batches are split. Returns an error
+// for the invalid size or model.
+func BatchSize(conn *gorm.DB, model interface{}) (int, error) {
+	size := 100
+	if value := os.Getenv("DB_BATCH_SIZE"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid DB_BATCH_SIZE %q", value)
+		}
+		size = n
+	}
+	limit, ok := paramLimits[conn.Dialector.Name()]
+	if !ok {
+		return size, nil
+	}
+	stmt := &gorm.Statement{DB: conn}
+	if err := stmt.Parse(model); err != nil {
+		return 0, err
+	}
+	if max := limit / len(stmt.Schema.DBNames); size > max {
+		log.Debugf("DB_BATCH_SIZE %d is lowered to %d", size, max)
+		size = max
+	}
+	return size, nil
+}
+
+// The function returns the conflict clause of the model rows inserted
+// by the primary key, which overwrites the other columns of the stored
+// rows with the inserted values as they are, including the timestamps.
+// Returns an error for the invalid model.
+func Overwrite(conn *gorm.DB, model interface{}) (clause.OnConflict, error) {
+	stmt := &gorm.Statement{DB: conn}
+	if err := stmt.Parse(model); err != nil {
+		return clause.OnConflict{}, err
+	}
+	var keys []clause.Column
+	var columns []string
+	for _, field := range stmt.Schema.Fields {
+		switch {
+		case field.DBName == "":
+		case field.PrimaryKey:
+			keys = append(keys, clause.Column{Name: field.DBName})
+		default:
+			columns = append(columns, field.DBName)
+		}
+	}
+	return clause.OnConflict{
+		Columns:   keys,
+		DoUpdates: clause.AssignmentColumns(columns),
+	}, nil
+}
+
+// The function moves the ID sequence of the model table past the
+// largest stored ID, as the rows inserted with the explicit IDs don't
+// advance it. Only PostgreSQL has to be synced. Returns an error for
+// the invalid model or the failed query.
+func SyncSequence(conn *gorm.DB, model interface{}) error {
+	if conn.Dialector.Name() != "postgres" {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: conn}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	table := stmt.Schema.Table
+	return conn.Exec(
+		"SELECT setval(pg_get_serial_sequence(?, 'id'), MAX(id)) FROM "+
+			conn.Statement.Quote(table),
+		table,
+	).Error
+}
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"os"
 	"people/logging"
+	"strconv"
+	"time"
 
-	"github.com/gin-gonic/gin"
 	_ "github.com/joho/godotenv/autoload"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 var (
@@ -18,7 +20,8 @@ var (
 
 // The function initializes the connection data from the environment
 // variables, performs a database connection, otherwise return an error
-// with the program shutdown.
+// with the program shutdown. The test database is chosen by the
+// DB_USE_TEST flag regardless of the Gin running mode.
 func Connect() {
 	f := logging.F()
 	host := os.Getenv("DB_HOST")
@@ -27,21 +30,56 @@ func Connect() {
 	dbMain := os.Getenv("DB_MAIN")
 	dbTest := os.Getenv("DB_TEST")
 	port := os.Getenv("DB_PORT")
-	log.Infof("Gin running mode: %v", gin.Mode())
-	if gin.Mode() == gin.TestMode {
+	config, err := gormConfig()
+	if err != nil {
+		log.Fatal(f+"failed to parse database logging settings:", err)
+	}
+	useTest, _ := strconv.ParseBool(os.Getenv("DB_USE_TEST"))
+	if useTest {
 		dbMain = dbTest
 	}
 	dsn := fmt.Sprintf(
 		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
 		host, user, pass, dbMain, port,
 	)
-	var err error
 	C, err = gorm.Open(
 		postgres.Open(dsn),
-		&gorm.Config{Logger: logging.GL(log)},
+		&gorm.Config{Logger: logging.GL(log, config)},
 	)
 	log.Infof("Working with %s database...", dbMain)
 	if err != nil {
 		log.Fatal(f+"failed to initialize database:", err)
 	}
 }
+
+// The function reads the GORM logger settings from the environment
+// variables: the slow query threshold, the logging level and the
+// record not found error ignoring. Empty variables keep the defaults.
+func gormConfig() (logger.Config, error) {
+	config := logger.Config{
+		SlowThreshold: 200 * time.Millisecond,
+		LogLevel:      logger.Info,
+	}
+	if value := os.Getenv("DB_SLOW_THRESHOLD"); value != "" {
+		threshold, err := time.ParseDuration(value)
+		if err != nil {
+			return config, err
+		}
+		config.SlowThreshold = threshold
+	}
+	if value := os.Getenv("DB_LOG_LEVEL"); value != "" {
+		level, err := logging.GormLevel(value)
+		if err != nil {
+			return config, err
+		}
+		config.LogLevel = level
+	}
+	if value := os.Getenv("DB_IGNORE_NOT_FOUND"); value != "" {
+		ignore, err := strconv.ParseBool(value)
+		if err != nil {
+			return config, err
+		}
+		config.IgnoreRecordNotFoundError = ignore
+	}
+	return config, nil
+}
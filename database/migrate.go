@@ -0,0 +1,309 @@
+package database
+
+import (
+	"fmt"
+	"people/logging"
+	"people/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The model of the applied schema migration record.
+type SchemaMigration struct {
+	Version   uint      `json:"version" gorm:"primarykey;autoIncrement:false"`
+	Name      string    `json:"name" gorm:"not null"`
+	AppliedAt time.Time `json:"applied_at" gorm:"not null"`
+}
+
+// The versioned schema change applied once in the order of versions.
+type Migration struct {
+	Version uint                    `json:"version"`
+	Name    string                  `json:"name"`
+	Up      func(tx *gorm.DB) error `json:"-"`
+}
+
+// The models whose tables are compared with the database by Drift().
+var Models = []interface{}{
+	&models.Entry{},
+	&models.Provenance{},
+	&models.Merge{},
+}
+
+// The schema of the entries table created by the first migration. The
+// tables are created from the frozen copies of the models, so the later
+// fields of the models are added by their own migrations only.
+type entryV1 struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	Name        string         `gorm:"not null"`
+	Surname     string         `gorm:"not null"`
+	Patronymic  string         `gorm:"default:''"`
+	Age         uint8          `gorm:"not null"`
+	Gender      string         `gorm:"not null"`
+	Nationality string         `gorm:"not null"`
+	Source      string         `gorm:"default:''"`
+}
+
+// The method returns the table name of the frozen model.
+func (entryV1) TableName() string {
+	return "entries"
+}
+
+// The schema of the provenances table created by the second migration.
+type provenanceV2 struct {
+	ID          uint      `gorm:"primarykey"`
+	EntryID     uint      `gorm:"index;not null"`
+	Field       string    `gorm:"not null"`
+	Provider    string    `gorm:"not null"`
+	Value       string    `gorm:"not null"`
+	Probability float64   `gorm:"default:0"`
+	Count       int       `gorm:"default:0"`
+	FetchedAt   time.Time `gorm:"not null"`
+}
+
+// The method returns the table name of the frozen model.
+func (provenanceV2) TableName() string {
+	return "provenances"
+}
+
+// The schema of the merges table created by the fourth migration.
+type mergeV4 struct {
+	ID        uint      `gorm:"primarykey"`
+	KeptID    uint      `gorm:"index;not null"`
+	RemovedID uint      `gorm:"index;not null"`
+	Fields    string    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// The method returns the table name of the frozen model.
+func (mergeV4) TableName() string {
+	return "merges"
+}
+
+// The ordered schema history. New changes are appended with the next
+// version, applied migrations are never edited.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_entries",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&entryV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_provenances",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&provenanceV2{})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_entries_uuid",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&models.Entry{}, "UUID") {
+				err := tx.Migrator().AddColumn(&models.Entry{}, "UUID")
+				if err != nil {
+					return err
+				}
+				err = tx.Migrator().CreateIndex(&models.Entry{}, "UUID")
+				if err != nil {
+					return err
+				}
+			}
+			// The existing entries get their UUIDs for the UUID mode.
+			return tx.Model(&models.Entry{}).
+				Unscoped().
+				Where("uuid IS NULL").
+				UpdateColumn("uuid", gorm.Expr("gen_random_uuid()")).
+				Error
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_merges",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&mergeV4{})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_entries_verified",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&models.Entry{}, "Verified") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&models.Entry{}, "Verified")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_provenances_candidates",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&models.Provenance{}, "Candidates") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&models.Provenance{}, "Candidates")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "cascade_provenances_entry",
+		Up: func(tx *gorm.DB) error {
+			migrator := tx.Migrator()
+			if migrator.HasConstraint(&models.Entry{}, "Provenance") {
+				err := migrator.DropConstraint(&models.Entry{}, "Provenance")
+				if err != nil {
+					return err
+				}
+			}
+			return migrator.CreateConstraint(&models.Entry{}, "Provenance")
+		},
+	},
+}
+
+// The function applies the migrations missing in the history table in
+// the order of versions. Each migration runs in a transaction with its
+// record, otherwise returns an error.
+func Migrate(conn *gorm.DB, migrations []Migration) error {
+	_, err := Apply(conn, migrations)
+	return err
+}
+
+// The key of the PostgreSQL advisory lock held while the migrations are
+// applied.
+const migrationLock = 31151
+
+// The function applies the pending migrations like Migrate() and
+// returns the records of the applied ones. The records applied before
+// the failed migration are returned with its error. The concurrent
+// processes are serialized by the advisory lock, so the pending
+// migrations are read after the other process has applied them.
+func Apply(conn *gorm.DB, migrations []Migration) ([]SchemaMigration, error) {
+	var applied []SchemaMigration
+	err := conn.Connection(func(session *gorm.DB) error {
+		err := session.Exec("SELECT pg_advisory_lock(?)", migrationLock).Error
+		if err != nil {
+			return err
+		}
+		defer session.Exec("SELECT pg_advisory_unlock(?)", migrationLock)
+		applied, err = apply(session, migrations)
+		return err
+	})
+	return applied, err
+}
+
+// The function applies the pending migrations on the locked connection.
+func apply(conn *gorm.DB, migrations []Migration) ([]SchemaMigration, error) {
+	f := logging.F()
+	err := conn.AutoMigrate(&SchemaMigration{})
+	if err != nil {
+		return nil, err
+	}
+	_, pending, err := Status(conn, migrations)
+	if err != nil {
+		return nil, err
+	}
+	applied := make([]SchemaMigration, 0, len(pending))
+	for _, m := range pending {
+		record := SchemaMigration{
+			Version:   m.Version,
+			Name:      m.Name,
+			AppliedAt: time.Now(),
+		}
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			err := m.Up(tx)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&record).Error
+		})
+		if err != nil {
+			log.Errorf(f+"migration %d %s failed: %v", m.Version, m.Name, err)
+			return applied, err
+		}
+		log.Infof("Migration %d %s applied", m.Version, m.Name)
+		applied = append(applied, record)
+	}
+	return applied, nil
+}
+
+// The function returns the latest applied version and the migrations
+// missing in the history table in the order of versions without applying
+// them, otherwise an error. The missing history table means nothing is
+// applied yet.
+func Status(conn *gorm.DB, migrations []Migration) (uint, []Migration, error) {
+	var applied []SchemaMigration
+	if conn.Migrator().HasTable(&SchemaMigration{}) {
+		err := conn.Find(&applied).Error
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	var version uint
+	done := make(map[uint]bool, len(applied))
+	for _, m := range applied {
+		done[m.Version] = true
+		if m.Version > version {
+			version = m.Version
+		}
+	}
+	pending := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Version < pending[j].Version
+	})
+	return version, pending, nil
+}
+
+// The function compares the tables of the models with the database and
+// describes the missing tables, the missing columns and the columns
+// unknown to the models. Returns an empty list without the drift,
+// otherwise an error.
+func Drift(conn *gorm.DB) ([]string, error) {
+	drift := []string{}
+	for _, model := range Models {
+		stmt := &gorm.Statement{DB: conn}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		table := stmt.Schema.Table
+		if !conn.Migrator().HasTable(model) {
+			drift = append(drift, "missing table "+table)
+			continue
+		}
+		columns, err := conn.Migrator().ColumnTypes(model)
+		if err != nil {
+			return nil, err
+		}
+		existing := make(map[string]bool, len(columns))
+		for _, column := range columns {
+			existing[column.Name()] = true
+		}
+		known := make(map[string]bool, len(stmt.Schema.DBNames))
+		for _, name := range stmt.Schema.DBNames {
+			known[name] = true
+			if !existing[name] {
+				drift = append(drift, fmt.Sprintf(
+					"missing column %s.%s", table, name,
+				))
+			}
+		}
+		for _, column := range columns {
+			if !known[column.Name()] {
+				drift = append(drift, fmt.Sprintf(
+					"unknown column %s.%s", table, column.Name(),
+				))
+			}
+		}
+	}
+	return drift, nil
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	db "people/database"
+	"people/logging"
+	"people/models"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// This API handler streams all entries including the soft-deleted ones
+// from the database cursor as JSON Lines, one entry with all its fields
+// and timestamps per line, for the backup restored by ImportJSONL().
+// Requires the administrator token. The error in the middle of the
+// stream aborts the response.
+func ExportJSONL(c *gin.Context) {
+	f := logging.F()
+	if !isAdmin(c) {
+		sendError(c, 401, models.CodeUnauthorized, "Unauthorized", nil)
+		return
+	}
+	rows, err := db.C.WithContext(c.Request.Context()).
+		Model(&models.Entry{}).
+		Unscoped().
+		Order("id").
+		Rows()
+	if err != nil {
+		log.Error(f+"request to the database failed: ", err)
+		sendError(c, 500, models.CodeInternal, "Export failed", nil)
+		return
+	}
+	defer rows.Close()
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="export.jsonl"`)
+	c.Status(200)
+	encoder := json.NewEncoder(c.Writer)
+	exported := 0
+	for rows.Next() {
+		var entry models.Entry
+		err = db.C.ScanRows(rows, &entry)
+		if err == nil {
+			err = encoder.Encode(models.StoredEntry(entry))
+		}
+		if err != nil {
+			break
+		}
+		exported++
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.Error(f+"export aborted: ", err)
+		c.Abort()
+		return
+	}
+	log.Infof(f+"%d entries exported", exported)
+}
+
+// This API handler restores the entries from the JSON Lines body of
+// the ExportJSONL() backup, upserting them by the ID, the entries
+// without the ID are created. The lines are restored as exported, only
+// the malformed ones and the ones repeating the ID or the UUID of an
+// earlier line or of another stored entry are rejected. The restored
+// entries are saved in one transaction in batches of DB_BATCH_SIZE.
+// Requires the administrator token. Return a JSON report with the
+// number of saved entries and the errors per line, or an error with its
+// cause.
+func ImportJSONL(c *gin.Context) {
+	f := logging.F()
+	if !isAdmin(c) {
+		sendError(c, 401, models.CodeUnauthorized, "Unauthorized", nil)
+		return
+	}
+	maxBytes, err := uploadLimit()
+	if err != nil {
+		log.Error(f+"invalid upload size limit: ", err)
+		sendError(c, 500, models.CodeInternal, "Import failed", nil)
+		return
+	}
+	reader := bufio.NewReader(
+		http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes),
+	)
+	var lines []restoredLine
+	report := []lineError{}
+	ids := make(map[uint]int)
+	uuids := make(map[string]int)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			log.Debug(f+"upload failed: ", err)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				sendError(c, 413, models.CodeTooLarge, "Body is too large", err)
+				return
+			}
+			sendError(c, 400, models.CodeBadRequest, "Invalid upload", err)
+			return
+		}
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 {
+			entry, lineErr := restoredEntry(trimmed)
+			if lineErr == nil {
+				lineErr = restoredUnique(entry, line, ids, uuids)
+			}
+			if lineErr != nil {
+				report = append(report, lineError{line, lineErr.Error()})
+			} else {
+				lines = append(lines, restoredLine{line, entry})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	conflicts, err := uuidConflicts(c.Request.Context(), lines)
+	if err != nil {
+		log.Error(f+"request to the database failed: ", err)
+		sendError(c, 500, models.CodeInternal, "Failed to import", nil)
+		return
+	}
+	var restored, created []models.Entry
+	for _, v := range lines {
+		if err := conflicts[v.line]; err != nil {
+			report = append(report, lineError{v.line, err.Error()})
+		} else if v.entry.ID != 0 {
+			restored = append(restored, v.entry)
+		} else {
+			created = append(created, v.entry)
+		}
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Line < report[j].Line
+	})
+	log.WithFields(logrus.Fields{
+		"Restored": len(restored),
+		"Created":  len(created),
+		"Invalid":  len(report),
+	}).Debug(f + "JSON Lines import")
+	if len(restored)+len(created) > 0 {
+		err = db.C.Transaction(func(tx *gorm.DB) error {
+			return restoreEntries(tx, restored, created)
+		})
+		if err != nil {
+			log.Error(f+"failed to import entries: ", err)
+			sendError(c, 500, models.CodeInternal, "Failed to import", nil)
+			return
+		}
+		invalidateCache(f)
+	}
+	c.JSON(200, gin.H{
+		"imported": len(restored) + len(created),
+		"errors":   report,
+	})
+}
+
+// The function upserts the restored entries by their IDs and moves the
+// ID sequence past them before the entries without the ID are created,
+// otherwise returns an error.
+func restoreEntries(
+	tx *gorm.DB,
+	restored []models.Entry,
+	created []models.Entry,
+) error {
+	size, err := db.BatchSize(tx, &models.Entry{})
+	if err != nil {
+		return err
+	}
+	if len(restored) > 0 {
+		overwrite, err := db.Overwrite(tx, &models.Entry{})
+		if err != nil {
+			return err
+		}
+		err = tx.Clauses(overwrite).CreateInBatches(&restored, size).Error
+		if err != nil {
+			return err
+		}
+		err = db.SyncSequence(tx, &models.Entry{})
+		if err != nil {
+			return err
+		}
+	}
+	if len(created) > 0 {
+		return tx.CreateInBatches(&created, size).Error
+	}
+	return nil
+}
+
+// The entry restored from the line of the JSON Lines backup.
+type restoredLine struct {
+	line  int
+	entry models.Entry
+}
+
+// The function decodes the Entry model from the exported line with the
+// unknown fields rejected and the UUID checked, otherwise returns an
+// error. The fields are not validated, so the entries are restored as
+// they were exported regardless of the current validation settings.
+func restoredEntry(data []byte) (models.Entry, error) {
+	var entry models.Entry
+	err := entry.DecodeStrict(bytes.NewReader(data))
+	if err != nil {
+		return entry, err
+	}
+	if entry.UUID != nil {
+		if _, err := uuid.Parse(*entry.UUID); err != nil {
+			return entry, fmt.Errorf(`invalid UUID "%s"`, *entry.UUID)
+		}
+	}
+	return entry, nil
+}
+
+// The function checks that the ID and the UUID of the restored entry
+// are not repeated by the earlier lines and records them, otherwise
+// returns an error with the first line.
+func restoredUnique(
+	entry models.Entry,
+	line int,
+	ids map[uint]int,
+	uuids map[string]int,
+) error {
+	if first, ok := ids[entry.ID]; ok && entry.ID != 0 {
+		return fmt.Errorf("ID %d repeats line %d", entry.ID, first)
+	}
+	if entry.UUID != nil {
+		if first, ok := uuids[*entry.UUID]; ok {
+			return fmt.Errorf("UUID %s repeats line %d", *entry.UUID, first)
+		}
+		uuids[*entry.UUID] = line
+	}
+	if entry.ID != 0 {
+		ids[entry.ID] = line
+	}
+	return nil
+}
+
+// The function finds the restored entries whose UUIDs belong to other
+// stored entries, including the soft-deleted ones. Returns the errors
+// by the lines, otherwise an error of the database.
+func uuidConflicts(
+	ctx context.Context,
+	lines []restoredLine,
+) (map[int]error, error) {
+	byUUID := make(map[string]restoredLine)
+	var list []string
+	for _, v := range lines {
+		if v.entry.UUID != nil {
+			byUUID[*v.entry.UUID] = v
+			list = append(list, *v.entry.UUID)
+		}
+	}
+	conflicts := make(map[int]error)
+	if len(list) == 0 {
+		return conflicts, nil
+	}
+	var stored []models.Entry
+	err := db.C.WithContext(ctx).
+		Unscoped().
+		Select("id", "uuid").
+		Where("uuid IN ?", list).
+		Find(&stored).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range stored {
+		restored := byUUID[*v.UUID]
+		if restored.entry.ID != v.ID {
+			conflicts[restored.line] = fmt.Errorf(
+				"UUID %s belongs to entry %d", *v.UUID, v.ID,
+			)
+		}
+	}
+	return conflicts, nil
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"os"
+	"people/logging"
+	"people/models"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The slots of the batch enrichments shared by all requests, so that
+// the concurrent requests don't multiply the ENRICH_BATCH_WORKERS.
+var batchSlots = make(chan struct{}, batchWorkers())
+
+// The function returns the number of the concurrent batch enrichments
+// from the ENRICH_BATCH_WORKERS environment variable, 3 by default.
+func batchWorkers() int {
+	workers, err := strconv.Atoi(os.Getenv("ENRICH_BATCH_WORKERS"))
+	if err != nil || workers < 1 {
+		return 3
+	}
+	return workers
+}
+
+// The result of the name enrichment in the batch.
+type enrichResult struct {
+	Name        string `json:"name"`
+	Age         uint8  `json:"age"`
+	Gender      string `json:"gender"`
+	Nationality string `json:"nationality"`
+	Error       string `json:"error,omitempty"`
+}
+
+// This API handler enriches up to ENRICH_BATCH_MAX names by the providers
+// with ENRICH_BATCH_WORKERS concurrent enrichments across all requests
+// without saving them. The repeated names are enriched once. Return a JSON message with the
+// results in the order of the names or an error with its cause.
+func EnrichBatch(c *gin.Context) {
+	f := logging.F()
+	var req struct {
+		Names []string `json:"names" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Debug(f+"parsing failed: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid API query", err)
+		return
+	}
+	max, err := strconv.Atoi(os.Getenv("ENRICH_BATCH_MAX"))
+	if err != nil || max < 1 {
+		max = 100
+	}
+	if len(req.Names) > max {
+		sendError(
+			c,
+			413,
+			models.CodeTooLarge,
+			"Too many names, the maximum is "+strconv.Itoa(max),
+			nil,
+		)
+		return
+	}
+	unique := make(map[string]*enrichResult)
+	for _, name := range req.Names {
+		unique[name] = &enrichResult{Name: name}
+	}
+	ctx := c.Request.Context()
+	var wg sync.WaitGroup
+	for name, result := range unique {
+		select {
+		case batchSlots <- struct{}{}:
+		case <-ctx.Done():
+			result.Error = ctx.Err().Error()
+			continue
+		}
+		wg.Add(1)
+		go func(name string, result *enrichResult) {
+			defer wg.Done()
+			defer func() { <-batchSlots }()
+			var entry models.Entry
+			if err := entry.EnrichContext(ctx, name); err != nil {
+				log.Debug(f+"failed to enrich data from API: ", err)
+				result.Error = err.Error()
+				return
+			}
+			result.Age = entry.Age
+			result.Gender = entry.Gender
+			result.Nationality = entry.Nationality
+		}(name, result)
+	}
+	wg.Wait()
+	if timedOut(c) {
+		sendError(c, 504, models.CodeTimeout, "Request timed out", nil)
+		return
+	}
+	results := make([]enrichResult, len(req.Names))
+	for i, name := range req.Names {
+		results[i] = *unique[name]
+	}
+	c.JSON(200, gin.H{"results": results})
+}
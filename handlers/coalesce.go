@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// The cache invalidation of the entries created from the Apache Kafka
+// messages.
+var ingestInvalidation = &coalescer{}
+
+// The cache invalidation coalesced within the interval. The first one
+// is immediate, the following ones within the interval are merged into
+// one at its end, so the reads reflect the latest data.
+type coalescer struct {
+	mu    sync.Mutex
+	last  time.Time
+	timer *time.Timer
+}
+
+// The method invalidates the entries cache at most once per the
+// CACHE_INVALIDATE_INTERVAL, on every call if it is empty or zero.
+func (c *coalescer) invalidate(f string) {
+	interval, err := time.ParseDuration(
+		os.Getenv("CACHE_INVALIDATE_INTERVAL"),
+	)
+	if err != nil || interval <= 0 {
+		invalidateCache(f)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		return
+	}
+	wait := time.Until(c.last.Add(interval))
+	if wait > 0 {
+		c.timer = time.AfterFunc(wait, func() { c.flush(f) })
+		return
+	}
+	c.last = time.Now()
+	invalidateCache(f)
+}
+
+// The method runs the pending invalidation at once, if any.
+func (c *coalescer) flush(f string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer == nil {
+		return
+	}
+	c.timer.Stop()
+	c.timer = nil
+	c.last = time.Now()
+	invalidateCache(f)
+}
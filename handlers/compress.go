@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+// The marker of the gzip compressed cache values. The values without it
+// are plain JSON, so both coexist in Redis during the rollout.
+var gzipMarker = []byte("gzip:")
+
+// The function compresses the cache value with CACHE_COMPRESS=gzip,
+// otherwise returns the value as is.
+func compress(value []byte) ([]byte, error) {
+	if os.Getenv("CACHE_COMPRESS") != "gzip" {
+		return value, nil
+	}
+	var buf bytes.Buffer
+	buf.Write(gzipMarker)
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(value); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// The function decompresses the marked cache value regardless of
+// CACHE_COMPRESS, the plain value is returned as is.
+func decompress(value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, gzipMarker) {
+		return value, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(value[len(gzipMarker):]))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"net/url"
+	"os"
+	"people/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The configuration variables reported by the Config() handler with the
+// defaults the code applies to the empty values.
+var configDefaults = [][2]string{
+	{"GIN_MODE", ""},
+	{"APP_ENV", "development"},
+	{"LOG_MODE", ""},
+	{"DUPLICATE_MODE", "insert"},
+	{"DEDUP_WINDOW", "0s"},
+	{"READ_ONLY_TTL", "1s"},
+	{"IMPORT_MAX_BYTES", "10485760"},
+	{"VALIDATE_BATCH_MAX", "1000"},
+	{"ID_TYPE", "int"},
+	{"SORT_DEFAULT", ""},
+	{"LEGACY_FILTER", "warn"},
+	{"INFLIGHT_MAX", "1000"},
+	{"GRAPHQL_ALLOWLIST", "false"},
+	{"GRAPHQL_PERSISTED_TTL", "24h"},
+	{"GRAPHQL_PERSISTED_MAX", "1000"},
+	{"GRAPHQL_MAX_NODES", "1000"},
+	{"MASK_FIELDS", ""},
+	{"STRICT_JSON", "false"},
+	{"WEBHOOK_RETRIES", "3"},
+	{"WEBHOOK_BACKOFF", "1s"},
+	{"SHUTDOWN_TIMEOUT", "10s"},
+	{"TLS_CERT", ""},
+	{"TLS_KEY", ""},
+	{"REQUEST_TIMEOUT", "30s"},
+	{"REQUEST_MAX_CONCURRENT", "0"},
+	{"SECURE_FRAME_DENY", "true"},
+	{"SECURE_STS", "true"},
+	{"SECURE_NOSNIFF", "true"},
+	{"SECURE_XSS_FILTER", "true"},
+	{"SECURE_CSP", "true"},
+	{"ROUTE_TRAILING_SLASH", "true"},
+	{"ROUTE_CASE_INSENSITIVE", "false"},
+	{"AK_ADDR", ""},
+	{"DATA", ""},
+	{"FAIL", ""},
+	{"RESULT", ""},
+	{"AK_MAX_PARTITIONS", "0"},
+	{"AK_FETCH_MIN", "1"},
+	{"AK_FETCH_DEFAULT", "1048576"},
+	{"AK_FETCH_MAX_WAIT", "500ms"},
+	{"AK_GROUP", "people"},
+	{"AK_COMMIT_EVERY", "100"},
+	{"AK_COMMIT_INTERVAL", "1s"},
+	{"AK_START_TIMESTAMP", ""},
+	{"ENRICH_MODE", "parallel"},
+	{"ENRICH_PROVIDER", "public"},
+	{"ENRICH_TTL", "1h"},
+	{"ENRICH_NEG_TTL", "5m"},
+	{"ENRICH_NAME_MAX", "50"},
+	{"ENRICH_CACHE_MAX", "10000"},
+	{"ENRICH_SINGLE_URL", ""},
+	{"ENRICH_TIMEOUT", "0"},
+	{"ENRICH_HTTP_TIMEOUT", "10s"},
+	{"ENRICH_COUNTRY", ""},
+	{"ENRICH_BATCH_WINDOW", "0"},
+	{"ENRICH_BATCH_MAX", "100"},
+	{"ENRICH_BATCH_WORKERS", "3"},
+	{"ENRICH_PATRONYMIC", "false"},
+	{"ENRICH_TRANSLIT", "false"},
+	{"ENRICH_MIN_CONFIDENCE", "0"},
+	{"ENRICH_LOW_CONFIDENCE", "unknown"},
+	{"ENRICH_QUOTA_LOW", "10"},
+	{"ENRICH_QUOTA_DELAY", "1s"},
+	{"ENRICH_MAX_IDLE_CONNS", "100"},
+	{"ENRICH_MAX_IDLE_PER_HOST", "32"},
+	{"ENRICH_MAX_CONNS_PER_HOST", "0"},
+	{"ENRICH_IDLE_TIMEOUT", "90s"},
+	{"ENRICH_KEEP_ALIVE", "true"},
+	{"ENRICH_AGE_MIN", "1"},
+	{"ENRICH_AGE_MAX", "120"},
+	{"ENRICH_NATIONALITY_MAX", "5"},
+	{"REENRICH_INTERVAL", ""},
+	{"REENRICH_STALE", "720h"},
+	{"REENRICH_BATCH", "50"},
+	{"REENRICH_WORKERS", "3"},
+	{"PURGE_INTERVAL", ""},
+	{"DELETE_RETENTION", "720h"},
+	{"RD_ADDR", ""},
+	{"RD_MAIN", ""},
+	{"RD_CONNECT_RETRIES", "3"},
+	{"RD_CONNECT_BACKOFF", "500ms"},
+	{"RD_REQUIRED", "false"},
+	{"CACHE_COMPRESS", "none"},
+	{"CACHE_NAMESPACE", ""},
+	{"CACHE_INVALIDATE_INTERVAL", "0s"},
+	{"DB_HOST", ""},
+	{"DB_PORT", ""},
+	{"DB_MAIN", ""},
+	{"DB_TEST", ""},
+	{"DB_USE_TEST", "false"},
+	{"DB_SLOW_THRESHOLD", "200ms"},
+	{"DB_LOG_LEVEL", "info"},
+	{"DB_IGNORE_NOT_FOUND", "false"},
+	{"DB_BATCH_SIZE", "100"},
+	{"MIGRATE_API", ""},
+}
+
+// The environment variables with the credentials, reported only as set
+// or not by the Config() handler and the startup summary.
+var Secrets = []string{
+	"ADMIN_TOKEN",
+	"API_KEYS",
+	"DB_USER",
+	"DB_PASSWORD",
+	"WEBHOOK_SECRET",
+	"WEBHOOK_URLS",
+}
+
+// The function hides the credentials and the query values of the URL,
+// as the provider URLs may carry the API keys.
+func redactURL(value string) string {
+	link, err := url.Parse(value)
+	if err != nil {
+		return "[REDACTED]"
+	}
+	if link.User != nil {
+		link.User = url.User("REDACTED")
+	}
+	query := link.Query()
+	for key := range query {
+		query.Set(key, "REDACTED")
+	}
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// This API handler returns the effective configuration of the running
+// process with the credentials and the URL secrets redacted. Requires
+// the administrator token. Return a JSON message with the settings or
+// an error with its cause.
+func Config(c *gin.Context) {
+	if !isAdmin(c) {
+		sendError(c, 401, models.CodeUnauthorized, "Unauthorized", nil)
+		return
+	}
+	config := make(map[string]interface{}, len(configDefaults)+16)
+	for _, item := range configDefaults {
+		config[item[0]] = item[1]
+		if value := os.Getenv(item[0]); value != "" {
+			config[item[0]] = value
+		}
+	}
+	for _, name := range Secrets {
+		config[name] = "unset"
+		if os.Getenv(name) != "" {
+			config[name] = "[REDACTED]"
+		}
+	}
+	def, max, err := pageLimits()
+	if err == nil {
+		config["PAGE_SIZE"], config["PAGE_SIZE_MAX"] = def, max
+	}
+	config["CACHE_TTL"] = cacheTTL.String()
+	config["CACHE_EMPTY_TTL"] = emptyTTL.String()
+	config["READ_ONLY"] = readOnly()
+	config["GIN_MODE"] = gin.Mode()
+	config["ENRICH_SINGLE_URL"] = redactURL(os.Getenv("ENRICH_SINGLE_URL"))
+	config["AGIFY_URL"] = redactURL(models.AgifyURL)
+	config["GENDERIZE_URL"] = redactURL(models.GenderizeURL)
+	config["NATIONALIZE_URL"] = redactURL(models.NationalizeURL)
+	c.JSON(200, gin.H{"config": config})
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"people/logging"
+	"time"
+)
+
+// The Redis key prefix of the recently seen Apache Kafka messages.
+const dedupPrefix = "dedup:"
+
+// The deduplication window of the repeated messages.
+var dedupWindow time.Duration
+
+// The function parses the DEDUP_WINDOW of the repeated messages. The
+// empty or zero window disables the deduplication, the invalid one is
+// fatal.
+func initDedup() {
+	dedupWindow = 0
+	value := os.Getenv("DEDUP_WINDOW")
+	if value == "" {
+		return
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		log.Fatalf("Failed to parse deduplication window %q", value)
+	}
+	dedupWindow = window
+}
+
+// The function returns the Redis key of the message payload.
+func dedupKey(msg []byte) string {
+	sum := sha256.Sum256(msg)
+	return nsKey(dedupPrefix + hex.EncodeToString(sum[:]))
+}
+
+// The function reports whether the same message payload was seen within
+// the deduplication window and marks it as seen otherwise. The message
+// is processed when the deduplication is disabled or Redis fails.
+func seenRecently(msg []byte) bool {
+	f := logging.F()
+	if dedupWindow <= 0 {
+		return false
+	}
+	set, err := cRedis.SetNX(ctx, dedupKey(msg), 1, dedupWindow).Result()
+	if err != nil {
+		log.Error(f+"deduplication failed: ", err)
+		return false
+	}
+	return !set
+}
+
+// The function removes the seen mark of the message payload not saved,
+// so that its redelivery is processed again.
+func forgetSeen(msg []byte) {
+	f := logging.F()
+	if dedupWindow <= 0 {
+		return
+	}
+	if err := cRedis.Del(ctx, dedupKey(msg)).Err(); err != nil {
+		log.Error(f+"failed to remove the deduplication mark: ", err)
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"people/logging"
+	"people/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// The function returns the entry by the ID from the query parameter,
+// otherwise responds with an error and returns false.
+func diffEntry(c *gin.Context, param string) (models.Entry, bool) {
+	f := logging.F()
+	var entry models.Entry
+	id := c.Query(param)
+	if id == "" {
+		message := fmt.Sprintf(`Fill in the "%s"`, param)
+		sendError(c, 400, models.CodeBadRequest, message, nil)
+		return entry, false
+	}
+	cond, arg, err := models.EntryCond(id)
+	if err != nil {
+		log.Debug(f+"invalid entry ID: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid ID parameter", err)
+		return entry, false
+	}
+	err = store.First(c.Request.Context(), &entry, cond, arg)
+	if err != nil {
+		sendError(
+			c,
+			404,
+			models.CodeNotFound,
+			fmt.Sprintf(`Entry "%v" does not exist`, id),
+			nil,
+		)
+		return entry, false
+	}
+	return entry, true
+}
+
+// This API handler reads the "a" and "b" entry IDs from the query and
+// compares the entries field by field for the manual merge of the
+// possible duplicates. Return a JSON message with both entries and the
+// "match" or "differ" state of each field, or an error with its cause.
+func Diff(c *gin.Context) {
+	f := logging.F()
+	log.WithFields(logrus.Fields{
+		"A": c.Query("a"),
+		"B": c.Query("b"),
+	}).Debug(f + "diff IDs")
+	a, ok := diffEntry(c, "a")
+	if !ok {
+		return
+	}
+	b, ok := diffEntry(c, "b")
+	if !ok {
+		return
+	}
+	fields := map[string][2]interface{}{
+		"name":        {a.Name, b.Name},
+		"surname":     {a.Surname, b.Surname},
+		"patronymic":  {a.Patronymic, b.Patronymic},
+		"age":         {a.Age, b.Age},
+		"gender":      {a.Gender, b.Gender},
+		"nationality": {a.Nationality, b.Nationality},
+		"source":      {a.Source, b.Source},
+	}
+	diff := make(map[string]string, len(fields))
+	for field, values := range fields {
+		diff[field] = "differ"
+		if values[0] == values[1] {
+			diff[field] = "match"
+		}
+	}
+	entries := []models.Entry{a, b}
+	maskFor(c, entries)
+	respond(c, 200, gin.H{"a": entries[0], "b": entries[1], "diff": diff})
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"io"
+	"os"
+	"people/logging"
+	"people/models"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The subscribers buffer size. Events for a subscriber with a full
+// buffer are dropped so that a slow client never blocks ProcessMsg().
+const eventsBuffer = 64
+
+var events = broker{subs: make(map[chan models.Event]struct{})}
+
+// The in-process fan-out of the message processing events.
+type broker struct {
+	mu   sync.RWMutex
+	subs map[chan models.Event]struct{}
+}
+
+// The method registers a new subscriber and returns its channel.
+func (b *broker) subscribe() chan models.Event {
+	ch := make(chan models.Event, eventsBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// The method removes the subscriber and closes its channel.
+func (b *broker) unsubscribe(ch chan models.Event) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+	close(ch)
+}
+
+// The method sends the event to all subscribers without waiting.
+func (b *broker) publish(event models.Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+			log.Warnf(
+				"Event %s of %s for a slow subscriber dropped",
+				event.Status, event.Source,
+			)
+		}
+	}
+}
+
+// The function creates the failure event from the rejected message.
+func failedEvent(msg models.FullName) models.Event {
+	return models.Event{
+		Status:  models.EventFailed,
+		Source:  msg.Source,
+		Name:    msg.Name,
+		Surname: msg.Surname,
+		Reason:  msg.Error,
+	}
+}
+
+// This API handler streams the message processing events as
+// Server-Sent Events until the client disconnects. The names are masked
+// for the client without the unmasked access.
+func Events(c *gin.Context) {
+	f := logging.F()
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+	log.Debug(f + "subscriber connected")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	masked := os.Getenv("MASK_FIELDS") != "" && !unmasked(c)
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-ch:
+			if masked {
+				event = maskEvent(event)
+			}
+			c.SSEvent(event.Status, event)
+			return true
+		case <-c.Request.Context().Done():
+			log.Debug(f + "subscriber disconnected")
+			return false
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"people/logging"
+	"people/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// The Redis key of the next offset of the fail topic to drain, separate
+// from the offset of RequeueFailures().
+const drainOffsetKey = "failures:drained"
+
+// The function reads up to limit messages of the fail topic in their
+// order after the drain offset stored in Redis. The offset is advanced
+// only in the consume mode, otherwise the messages stay for the next
+// reading. Returns the messages and the next offset, otherwise an error
+// with its cause.
+func DrainFailures(limit int, consume bool) ([]json.RawMessage, int64, error) {
+	f := logging.F()
+	if failProducer == nil {
+		return nil, 0, errors.New("kafka consumer is not started")
+	}
+	offset, err := cRedis.Get(ctx, nsKey(drainOffsetKey)).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, 0, err
+	}
+	if err == redis.Nil {
+		offset = -1
+	}
+	values, next, err := failTopic.Fetch(offset, limit)
+	if err != nil {
+		log.Error(f+"failed to read the fail topic: ", err)
+		return nil, offset, err
+	}
+	messages := make([]json.RawMessage, len(values))
+	for i, msg := range values {
+		// The undecodable messages are forwarded as is, so they are
+		// returned as JSON strings.
+		value := msg.Value
+		if !json.Valid(value) {
+			value, _ = json.Marshal(string(value))
+		}
+		messages[i] = value
+	}
+	if consume && len(values) > 0 {
+		err := cRedis.Set(ctx, nsKey(drainOffsetKey), next, 0).Err()
+		if err != nil {
+			log.Error(f+"failed to save the fail topic offset: ", err)
+			return messages, next, err
+		}
+		log.Infof(f+"failures consumed: %d", len(values))
+	}
+	return messages, next, nil
+}
+
+// This API handler returns up to "limit" messages of the fail topic
+// and keeps them for the next reading. Requires the administrator
+// token. Return a JSON message with the messages and the next offset or
+// an error with its cause.
+func PeekFailures(c *gin.Context) {
+	drainFailures(c, false)
+}
+
+// This API handler returns up to "limit" messages of the fail topic and
+// advances the drain offset past them. Requires the administrator
+// token. Return a JSON message with the messages and the next offset or
+// an error with its cause.
+func ConsumeFailures(c *gin.Context) {
+	drainFailures(c, true)
+}
+
+// The function serves the reading of the fail topic in the peek or the
+// consume mode.
+func drainFailures(c *gin.Context, consume bool) {
+	f := logging.F()
+	if !isAdmin(c) {
+		sendError(c, 401, models.CodeUnauthorized, "Unauthorized", nil)
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		log.Debug(f+"invalid limit: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid limit parameter", err)
+		return
+	}
+	messages, next, err := DrainFailures(limit, consume)
+	if err != nil {
+		log.Error(f+"failed to read failures: ", err)
+		sendError(c, 500, models.CodeInternal, "Request failed", nil)
+		return
+	}
+	c.JSON(200, gin.H{"messages": messages, "offset": next})
+}
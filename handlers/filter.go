@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"os"
+	"people/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The function handles the deprecated "col" and "data" filter according
+// to LEGACY_FILTER: "warn" serves it with the deprecation headers,
+// "allow" serves it silently and "reject" answers the error. Returns
+// false if the request is rejected.
+func legacyFilter(c *gin.Context) bool {
+	switch os.Getenv("LEGACY_FILTER") {
+	case "allow":
+		return true
+	case "reject":
+		sendError(
+			c,
+			400,
+			models.CodeBadRequest,
+			`The "col" and "data" parameters are removed, use "filter"`,
+			nil,
+		)
+		return false
+	default:
+		c.Header("Deprecation", "true")
+		c.Header(
+			"Warning",
+			`299 - "The col and data parameters are deprecated, use filter"`,
+		)
+		return true
+	}
+}
@@ -1,110 +1,454 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	db "people/database"
 	"people/kafka"
 	"people/logging"
 	"people/models"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
 	cRedis       *redis.Client
-	dataTopic    kafka.Topic
+	cacheTTL     time.Duration
+	emptyTTL     time.Duration
+	cacheNS      string
+	dataTopics   kafka.Topics
 	failTopic    kafka.Topic
+	resultTopic  kafka.Topic
 	failProducer sarama.AsyncProducer
-	dataCh       = make(chan []byte)
+	syncProducer sarama.SyncProducer
+	dataCh       = make(chan message)
 	ctx          = context.Background()
 	log          = logging.Config
 )
 
-// The function initializes the Redis credentials data from the
-// environment variables and triggers connection.
+// The default TTL of the cached entries.
+const defaultCacheTTL = 10 * time.Minute
+
+// The function initializes the Redis credentials data and the cache
+// TTL from the environment variables and triggers connection. The
+// entries are cached for CACHE_TTL, 10 minutes if empty. The empty
+// results are cached for CACHE_EMPTY_TTL, CACHE_TTL if empty, and are
+// not cached with the zero duration. The cache keys are prefixed with
+// the CACHE_NAMESPACE, if set. The DEDUP_WINDOW is parsed as well.
+// Redis unavailable after the connection retries is fatal with
+// RD_REQUIRED, otherwise the cache is disabled: the reads fall back to
+// the database until the client reconnects.
 func InitRedis(redisDB string) {
 	dbNum, err := strconv.Atoi(redisDB)
 	if err != nil {
 		log.Fatalf("Failed to parse Redis database number: %v", err)
 	}
+	cacheTTL = defaultCacheTTL
+	if value := os.Getenv("CACHE_TTL"); value != "" {
+		cacheTTL, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Failed to parse Redis cache TTL: %v", err)
+		}
+	}
+	emptyTTL = cacheTTL
+	if value := os.Getenv("CACHE_EMPTY_TTL"); value != "" {
+		emptyTTL, err = time.ParseDuration(value)
+		if err != nil || emptyTTL < 0 {
+			log.Fatalf("Failed to parse Redis empty cache TTL: %v", err)
+		}
+	}
+	cacheNS = os.Getenv("CACHE_NAMESPACE")
+	initDedup()
+	initPersisted()
+	initReadOnly()
 	cRedis = redis.NewClient(&redis.Options{
 		Addr: os.Getenv("RD_ADDR"),
 		DB:   dbNum,
 	})
-	_, err = cRedis.Ping(ctx).Result()
+	err = pingRedis()
 	if err != nil {
-		log.Fatalf("Redis connection failed: %v", err)
+		if os.Getenv("RD_REQUIRED") == "true" {
+			log.Fatalf("Redis connection failed: %v", err)
+		}
+		log.Errorf("Redis connection failed, cache disabled: %v", err)
+		return
 	}
 	log.Infof("Redis DB: %v", dbNum)
 }
 
-// The function triggers the consumer and producer of messages.
-func GetMsg(data kafka.Topic, fail kafka.Topic) {
-	dataTopic = data
+// The function pings Redis and retries failures RD_CONNECT_RETRIES
+// times, doubling the RD_CONNECT_BACKOFF delay.
+func pingRedis() error {
+	retries, err := strconv.Atoi(os.Getenv("RD_CONNECT_RETRIES"))
+	if err != nil || retries < 0 {
+		retries = 3
+	}
+	backoff, err := time.ParseDuration(os.Getenv("RD_CONNECT_BACKOFF"))
+	if err != nil || backoff <= 0 {
+		backoff = 500 * time.Millisecond
+	}
+	for attempt := 0; ; attempt++ {
+		_, err = cRedis.Ping(ctx).Result()
+		if err == nil || attempt >= retries {
+			return err
+		}
+		log.Warnf("Redis ping attempt %d failed: %v", attempt+1, err)
+		time.Sleep(backoff << attempt)
+	}
+}
+
+// The message of the Apache Kafka data topic with the name of its
+// source topic and the acknowledgement of its processing.
+type message struct {
+	source string
+	value  []byte
+	ack    func()
+}
+
+// The Redis key of the entries cache generation.
+const generationKey = "entries:generation"
+
+// The function prefixes the Redis key with the namespace, so that the
+// environments sharing the Redis instance don't read or invalidate the
+// cache, the offsets and the toggles of each other.
+func nsKey(key string) string {
+	if cacheNS == "" {
+		return key
+	}
+	return cacheNS + ":" + key
+}
+
+// The function returns the current generation of the entries cache.
+func generation(ctx context.Context) int64 {
+	gen, err := cRedis.Get(ctx, nsKey(generationKey)).Int64()
+	if err != nil && err != redis.Nil {
+		log.Error("Failed to get cache generation: ", err)
+	}
+	return gen
+}
+
+// The function invalidates the entries cache by incrementing its
+// generation. The keys of the stale generations are never read again
+// and expire by the cache TTL.
+func invalidateCache(f string) {
+	cacheOps.Add(1)
+	defer cacheOps.Add(-1)
+	gen, err := cRedis.Incr(ctx, nsKey(generationKey)).Result()
+	if err != nil {
+		log.Error(f+"cache invalidation failed: ", err)
+	} else {
+		log.Debug(f+"cache generation: ", gen)
+	}
+}
+
+// The function creates the entries cache key of the current generation
+// from the reading parameters.
+func entriesKey(
+	ctx context.Context,
+	size int,
+	page int,
+	col string,
+	data string,
+	filter *models.Filter,
+	sort string,
+	dates dateFilter,
+) string {
+	return nsKey(fmt.Sprintf(
+		"entries:%v:%v:%v:%s:%s:%s:%s:%s",
+		generation(ctx),
+		size,
+		page,
+		col,
+		data,
+		filter.Key(),
+		sort,
+		dates.key(),
+	))
+}
+
+// The function saves the entries into the cache by the key, compressed
+// according to CACHE_COMPRESS. The entries of the cancelled request are
+// not saved as nobody wanted them. The empty result is saved for the
+// empty cache TTL, so the "no data" state is not served for long while
+// the data is loaded.
+func cacheEntries(
+	ctx context.Context,
+	f string,
+	key string,
+	entries []models.Entry,
+) {
+	if err := ctx.Err(); err != nil {
+		log.Debug(f+"cache saving skipped: ", err)
+		return
+	}
+	ttl := cacheTTL
+	if len(entries) == 0 {
+		ttl = emptyTTL
+	}
+	if ttl <= 0 {
+		log.Debug(f + "empty result is not cached")
+		return
+	}
+	cacheOps.Add(1)
+	defer cacheOps.Add(-1)
+	stored := make([]models.StoredEntry, len(entries))
+	for i, entry := range entries {
+		stored[i] = models.StoredEntry(entry)
+	}
+	jsonData, err := json.Marshal(stored)
+	if err != nil {
+		log.Error(f+"serializing to JSON failed: ", err)
+		return
+	}
+	value, err := compress(jsonData)
+	if err != nil {
+		log.Error(f+"cache compression failed: ", err)
+		value = jsonData
+	}
+	cRedis.Set(ctx, key, value, ttl)
+}
+
+// The function reads the entries from the cache by the key. The corrupt
+// value is deleted and reported as a cache miss to read the database.
+func cached(
+	ctx context.Context,
+	f string,
+	key string,
+	entries *[]models.Entry,
+) bool {
+	cacheResult, err := cRedis.Get(ctx, key).Bytes()
+	if err != nil {
+		log.Debug(f+"cache error: ", err)
+		return false
+	}
+	var stored []models.StoredEntry
+	cacheResult, err = decompress(cacheResult)
+	if err == nil {
+		err = json.Unmarshal(cacheResult, &stored)
+	}
+	if err != nil {
+		log.Error(f+"JSON deserializing failed: ", err)
+		*entries = nil
+		cRedis.Del(ctx, key)
+		return false
+	}
+	*entries = make([]models.Entry, len(stored))
+	for i, entry := range stored {
+		(*entries)[i] = models.Entry(entry)
+	}
+	return true
+}
+
+// The function sets the caching hints of the read response for the
+// intermediaries aligned with the Redis cache TTL.
+func cacheControl(c *gin.Context) {
+	c.Header(
+		"Cache-Control",
+		fmt.Sprintf("private, max-age=%d", int(cacheTTL.Seconds())),
+	)
+	c.Header("Vary", "Accept-Encoding")
+}
+
+// The middleware forbids the caching of the responses of the write and
+// authorized requests by the intermediaries.
+func NoStore(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Next()
+}
+
+// This API handler reports the liveness of the server without checking
+// its dependencies. It is exempt from the load shedding, so a busy
+// instance is not restarted by its health checks. Return a JSON message
+// with the "ok" status.
+func Health(c *gin.Context) {
+	c.JSON(200, gin.H{"status": "ok"})
+}
+
+// This API handler answers the unknown routes with the JSON error like
+// the other handlers instead of the plain text.
+func NoRoute(c *gin.Context) {
+	sendError(c, 404, models.CodeNotFound, "Route not found", nil)
+}
+
+// This API handler answers the known routes requested by the unsupported
+// method with the JSON error like the other handlers.
+func NoMethod(c *gin.Context) {
+	sendError(
+		c, 405, models.CodeMethodNotAllowed, "Method not allowed", nil,
+	)
+}
+
+// The function sets the data and the fail topics with the producers of
+// messages for the API handlers of the failures without consuming. The
+// misconfigured topics stop the program.
+func SetTopics(data kafka.Topics, fail kafka.Topic) {
+	if err := kafka.CheckRoles(data, fail); err != nil {
+		log.Fatal("Kafka topics are misconfigured: ", err)
+	}
+	dataTopics = data
 	failTopic = fail
 	failProducer = kafka.NewProd()
-	go dataTopic.Consume(dataCh)
+	syncProducer = kafka.NewSyncProd()
+}
+
+// The function triggers the consumers of all data topics and the
+// producer of messages. The misconfigured topics stop the program. The
+// message is acknowledged for the offset commit after its processing.
+func GetMsg(data kafka.Topics, fail kafka.Topic) {
+	SetTopics(data, fail)
+	for _, topic := range dataTopics {
+		go consume(topic)
+	}
 	for {
-		go ProcessMsg(<-dataCh)
+		msg := <-dataCh
+		go func(msg message) {
+			ProcessMsg(msg.source, msg.value)
+			msg.ack()
+		}(msg)
+	}
+}
+
+// The function consumes the data topic with the committed offsets and
+// passes its messages tagged with the topic name into the shared
+// processing channel.
+func consume(topic kafka.Topic) {
+	ch := make(chan kafka.Message)
+	go topic.ConsumeCommitted(ch)
+	for msg := range ch {
+		dataCh <- message{source: topic.Name, value: msg.Value, ack: msg.Ack}
 	}
 }
 
 // The function processes, checks, enriches and saves correct incoming
-// messages to the database. Incorrect messages are enriched with the
-// cause of the error and sent to a separate topic.
-func ProcessMsg(msg []byte) {
+// messages from the source topic to the database. Incorrect messages
+// are enriched with the cause of the error and the source topic and
+// sent to a separate topic. The created entries are sent to the result
+// topic, if set. Possible duplicates by the full name are
+// rejected, skipped or inserted according to DUPLICATE_MODE. The same
+// payload repeated within the DEDUP_WINDOW after its saving is skipped.
+// The enrichment is bounded by the ENRICH_TIMEOUT, if set, and the
+// failures are counted by their reasons. The successful, the failed
+// and the skipped messages are counted for the throughput. The cache is
+// invalidated at most once per CACHE_INVALIDATE_INTERVAL.
+func ProcessMsg(source string, msg []byte) {
 	f := logging.F()
 	var dataMsg models.FullName
 	err := json.Unmarshal(msg, &dataMsg)
 	if err != nil {
 		log.Error(f+"JSON deserializing failed: ", err)
-		failTopic.Produce(msg, failProducer)
+		failed := models.FullName{
+			Source: source,
+			Error:  fmt.Sprintf("JSON deserializing failed: %v", err),
+		}
+		sendFailure(f, failValidation, msg, failed)
 		return
 	}
+	dataMsg.Normalize()
+	dataMsg.Source = source
+	id := inflight.add(dataMsg.Source, dataMsg.Name, dataMsg.Surname)
+	defer inflight.remove(id)
 	log.WithFields(logrus.Fields{
-		"Name":       dataMsg.Name,
-		"Surname":    dataMsg.Surname,
-		"Patronymic": dataMsg.Patronymic,
+		"Source":      dataMsg.Source,
+		"Name":        dataMsg.Name,
+		"Surname":     dataMsg.Surname,
+		"Patronymic":  dataMsg.Patronymic,
+		"Age":         dataMsg.Age,
+		"Gender":      dataMsg.Gender,
+		"Nationality": dataMsg.Nationality,
 	}).Debug(f + "dataMsg")
 	result := dataMsg.IsValid()
 	if result != "" {
 		log.Debug(f+"invalid message: ", result)
 		dataMsg.Error = result
-		jsonData, err := json.Marshal(dataMsg)
-		if err != nil {
-			log.Error(f+"serializing to JSON failed: ", err)
-			failTopic.Produce(msg, failProducer)
-			return
-		}
-		failTopic.Produce(jsonData, failProducer)
+		sendFailure(f, failValidation, msg, dataMsg)
 		return
 	}
 	entry := models.Entry{
-		Name:       dataMsg.Name,
-		Surname:    dataMsg.Surname,
-		Patronymic: dataMsg.Patronymic,
+		Name:        dataMsg.Name,
+		Surname:     dataMsg.Surname,
+		Patronymic:  dataMsg.Patronymic,
+		Age:         dataMsg.Age,
+		Gender:      dataMsg.Gender,
+		Nationality: dataMsg.Nationality,
+		Source:      dataMsg.Source,
+	}
+	if readOnly() {
+		log.Debug(f + "message rejected in read-only mode")
+		dataMsg.Error = "Read-only mode"
+		sendFailure(f, failRejected, msg, dataMsg)
+		return
+	}
+	if seenRecently(msg) {
+		log.Info(f + "message skipped: seen within the deduplication window")
+		countSkipped()
+		return
+	}
+	saved := false
+	defer func() {
+		if !saved {
+			forgetSeen(msg)
+		}
+	}()
+	dup, err := duplicateOf(entry)
+	if err != nil {
+		log.Error(f+"duplicate detection failed: ", err)
+	}
+	if dup != "" {
+		reason := fmt.Sprintf("Possible duplicate of ID %s", dup)
+		switch os.Getenv("DUPLICATE_MODE") {
+		case "reject":
+			log.Debug(f+"message rejected: ", reason)
+			dataMsg.Error = reason
+			sendFailure(f, failRejected, msg, dataMsg)
+			return
+		case "skip":
+			log.Info(f+"message skipped: ", reason)
+			countSkipped()
+			return
+		default:
+			log.Warn(f+"message inserted: ", reason)
+		}
+	}
+	enrichCtx := context.Background()
+	timeout, _ := time.ParseDuration(os.Getenv("ENRICH_TIMEOUT"))
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		enrichCtx, cancel = context.WithTimeout(enrichCtx, timeout)
+		defer cancel()
 	}
-	err = entry.Enrich(entry.Name)
+	err = entry.EnrichContext(enrichCtx, entry.Name)
 	if err != nil {
 		log.Error(f+"failed to enrich data from API: ", err)
 		dataMsg.Error = fmt.Sprintf("Failed to enrich data from API: %v", err)
-		jsonData, err := json.Marshal(dataMsg)
-		if err != nil {
-			log.Error(f+"serializing to JSON failed: ", err)
-			failTopic.Produce(msg, failProducer)
-			return
+		if errors.Is(err, models.ErrImplausible) {
+			dataMsg.Error = fmt.Sprintf("Rejected %v", err)
+		}
+		var enrichErr *models.EnrichError
+		if errors.As(err, &enrichErr) {
+			dataMsg.Status = enrichErr.Status
 		}
-		failTopic.Produce(jsonData, failProducer)
+		sendFailure(f, enrichReason(err), msg, dataMsg)
 		return
 	}
 	log.WithFields(logrus.Fields{
@@ -115,39 +459,156 @@ func ProcessMsg(msg []byte) {
 		"Age":         entry.Age,
 		"Gender":      entry.Gender,
 		"Nationality": entry.Nationality,
+		"Source":      entry.Source,
 	}).Debug(f + "entry")
-	err = db.C.Create(&entry).Error
+	err = store.Create(ctx, &entry)
 	if err != nil {
-		log.Error(f+"failed to create entry: ", err)
+		log.WithFields(entryFields(entry)).
+			Error(f+"failed to create entry: ", err)
 		dataMsg.Error = fmt.Sprintf("Failed to create entry: %v", err)
-		jsonData, err := json.Marshal(dataMsg)
-		if err != nil {
-			log.Error(f+"serializing to JSON failed: ", err)
-			failTopic.Produce(msg, failProducer)
-			return
-		}
-		failTopic.Produce(jsonData, failProducer)
+		sendFailure(f, failDB, msg, dataMsg)
 		return
 	}
-	status, err := cRedis.FlushAll(ctx).Result()
+	saved = true
+	events.publish(models.Event{
+		Status:  models.EventCreated,
+		Source:  entry.Source,
+		ID:      entry.ID,
+		Name:    entry.Name,
+		Surname: entry.Surname,
+	})
+	throughput.record(outcomeSuccess)
+	notifyCreated(entry)
+	produceResult(f, entry)
+	ingestInvalidation.invalidate(f)
+}
+
+// The function counts the failure by its reason, publishes its event and
+// sends the failed message with its error to the fail topic, the original
+// message if it is not serialized.
+func sendFailure(f string, reason string, msg []byte, failed models.FullName) {
+	countFailure(reason)
+	events.publish(failedEvent(failed))
+	jsonData, err := json.Marshal(failed)
 	if err != nil {
-		log.Error(f+"FLUSHALL failed: ", err)
-	} else {
-		log.Debug(f+"FLUSHALL success: ", status)
+		log.Error(f+"serializing to JSON failed: ", err)
+		failTopic.Produce(msg, failProducer)
+		return
 	}
+	failTopic.Produce(jsonData, failProducer)
+}
+
+// The function sets the optional topic of the enriched entries created
+// from the Apache Kafka messages, the empty name disables it. It must be
+// called before the GetMsg() function.
+func SetResultTopic(topic kafka.Topic) {
+	resultTopic = topic
+}
+
+// The function sends the enriched entry to the result topic, if set.
+func produceResult(f string, entry models.Entry) {
+	if resultTopic.Name == "" {
+		return
+	}
+	jsonData, err := json.Marshal(entry)
+	if err != nil {
+		log.Error(f+"serializing to JSON failed: ", err)
+		return
+	}
+	resultTopic.Produce(jsonData, failProducer)
+}
+
+// The function returns the public ID of the stored entry with the same
+// full name regardless of the case, otherwise an empty string.
+func duplicateOf(entry models.Entry) (string, error) {
+	var found models.Entry
+	err := store.First(
+		ctx,
+		&found,
+		"lower(name) = lower(?) AND lower(surname) = lower(?) "+
+			"AND lower(patronymic) = lower(?)",
+		entry.Name,
+		entry.Surname,
+		entry.Patronymic,
+	)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return found.PublicID(), nil
+}
+
+// The function responds with the standard error envelope. The cause
+// of the error is passed to the details only for the client faults.
+func sendError(
+	c *gin.Context,
+	status int,
+	code string,
+	message string,
+	cause error,
+) {
+	if status >= 500 && timedOut(c) {
+		status, code, message = 504, models.CodeTimeout, "Request timed out"
+	}
+	apiErr := models.Error{Code: code, Message: message}
+	if cause != nil {
+		apiErr.Details = cause.Error()
+	}
+	c.JSON(status, gin.H{"error": apiErr})
+}
+
+// The function responds with the data of the read endpoints. In the
+// debug mode the "pretty" flag indents the JSON for reading in a
+// terminal, otherwise the output is compact.
+func respond(c *gin.Context, status int, obj interface{}) {
+	if gin.Mode() == gin.DebugMode && c.Query("pretty") == "true" {
+		c.IndentedJSON(status, obj)
+		return
+	}
+	c.JSON(status, obj)
+}
+
+// The function binds the Entry model of the request. With STRICT_JSON
+// enabled the JSON body with the unknown fields is rejected instead of
+// ignoring them.
+func bindEntry(c *gin.Context, entry *models.Entry) error {
+	if os.Getenv("STRICT_JSON") == "true" &&
+		c.ContentType() == binding.MIMEJSON {
+		return entry.DecodeStrict(c.Request.Body)
+	}
+	return c.ShouldBind(entry)
+}
+
+// The function responds to the failed binding of the Entry model. The
+// invalid age values are reported as the filling errors.
+func sendBindError(c *gin.Context, err error) {
+	if errors.Is(err, models.ErrAgeNotInteger) ||
+		errors.Is(err, models.ErrAgeRange) {
+		sendError(c, 422, models.CodeValidationFailed, "Filling errors", err)
+		return
+	}
+	if errors.Is(err, models.ErrUnknownField) {
+		sendError(c, 400, models.CodeBadRequest, "Unknown field", err)
+		return
+	}
+	sendError(c, 400, models.CodeBadRequest, "Invalid API query", err)
 }
 
 // This API handler checks the input data, saves the record into the
 // database and dumps the Redis cache keys. Return a JSON success
-// message or an error with its cause.
+// message with the warnings of the suspicious data or an error with its
+// cause.
 func Create(c *gin.Context) {
 	f := logging.F()
 	var newEntry models.Entry
-	if err := c.ShouldBind(&newEntry); err != nil {
+	if err := bindEntry(c, &newEntry); err != nil {
 		log.Debug(f+"parsing failed: ", err)
-		c.JSON(400, gin.H{"error": "Invalid API query"})
+		sendBindError(c, err)
 		return
 	}
+	newEntry.Normalize()
 	log.WithFields(logrus.Fields{
 		"Name":        newEntry.Name,
 		"Surname":     newEntry.Surname,
@@ -158,118 +619,653 @@ func Create(c *gin.Context) {
 	}).Debug(f + "newEntry")
 	err := newEntry.IsValid()
 	if err != nil {
-		c.JSON(422, gin.H{"error": fmt.Sprintf("Filling errors: %v", err)})
+		sendError(c, 422, models.CodeValidationFailed, "Filling errors", err)
 		return
 	}
-	err = db.C.Create(&newEntry).Error
+	err = store.Create(c.Request.Context(), &newEntry)
 	if err != nil {
-		log.Error(f+"failed to create entry: ", err)
-		c.JSON(500, gin.H{"error": "Failed to create entry"})
+		log.WithFields(entryFields(newEntry)).
+			Error(f+"failed to create entry: ", err)
+		sendError(c, 500, models.CodeInternal, "Failed to create entry", nil)
 		return
 	}
-	status, err := cRedis.FlushAll(ctx).Result()
-	if err != nil {
-		log.Error(f+"FLUSHALL failed: ", err)
-	} else {
-		log.Debug(f+"FLUSHALL success: ", status)
+	notifyCreated(newEntry)
+	invalidateCache(f)
+	warnings := newEntry.Warnings()
+	if len(warnings) > 0 {
+		log.Debug(f+"suspicious entry: ", warnings)
+		c.JSON(200, gin.H{"message": "Success", "warnings": warnings})
+		return
 	}
 	c.JSON(200, gin.H{"message": "Success"})
 }
 
+// The function returns the entry fields for the error logs. The personal
+// names are masked to the first letter and the length to reproduce the
+// constraint violations without writing them to the logs.
+func entryFields(entry models.Entry) logrus.Fields {
+	return logrus.Fields{
+		"Name":        mask(entry.Name),
+		"Surname":     mask(entry.Surname),
+		"Patronymic":  mask(entry.Patronymic),
+		"Age":         entry.Age,
+		"Gender":      entry.Gender,
+		"Nationality": entry.Nationality,
+		"Source":      entry.Source,
+	}
+}
+
+// The function masks all letters of the value except the first one.
+func mask(value string) string {
+	runes := []rune(value)
+	for i := 1; i < len(runes); i++ {
+		runes[i] = '*'
+	}
+	return string(runes)
+}
+
 // This API handler reads filtering parameters, creates a caching key
 // to obtain data from Redis, otherwise it reads data from the database
-// with their conservation in cache. Return a JSON message with data or
-// an error with its cause.
+// with their conservation in cache. The "since", "since_id" and
+// "include_deleted" parameters switch it to the incremental sync bypassing
+// the cache. The "created_*" and "updated_*" parameters limit the date
+// range, the "ids" parameter reads the comma separated IDs in their order
+// instead. The "filter" parameter reads the structured filter in JSON or
+// base64, the deprecated "col" and "data" filter is handled according to
+// LEGACY_FILTER. Return a JSON message with data or an error with its
+// cause.
 func Read(c *gin.Context) {
 	f := logging.F()
-	pageSize := c.DefaultQuery("size", "10")
+	if idsParam := c.Query("ids"); idsParam != "" {
+		readIDs(c, idsParam)
+		return
+	}
+	pageSize := c.DefaultQuery("size", "0")
 	pageNum := c.DefaultQuery("page", "1")
 	filterCol := c.Query("col")
 	filterData := c.Query("data")
+	filterParam := c.Query("filter")
+	sortCol := c.Query("sort")
+	sinceParam := c.Query("since")
+	sinceIDParam := c.DefaultQuery("since_id", "0")
+	deletedParam := c.DefaultQuery("include_deleted", "false")
 	log.WithFields(logrus.Fields{
-		"Size":   pageSize,
-		"Num":    pageNum,
-		"Column": filterCol,
-		"Data":   filterData,
+		"Size":    pageSize,
+		"Num":     pageNum,
+		"Column":  filterCol,
+		"Data":    filterData,
+		"Filter":  filterParam,
+		"Sort":    sortCol,
+		"Since":   sinceParam,
+		"SinceID": sinceIDParam,
+		"Deleted": deletedParam,
 	}).Debug(f + "GET filters")
 	switch {
 	case filterCol != "" && filterData == "":
 		fallthrough
 	case filterCol == "" && filterData != "":
-		c.JSON(400, gin.H{"error": `Fill in both "col" and "data"`})
+		sendError(
+			c, 400, models.CodeBadRequest, `Fill in both "col" and "data"`, nil,
+		)
+		return
+	case filterParam != "" && filterCol != "":
+		sendError(
+			c,
+			400,
+			models.CodeBadRequest,
+			`Use either "filter" or "col" and "data"`,
+			nil,
+		)
+		return
+	}
+	if filterCol != "" && !legacyFilter(c) {
 		return
 	}
 	intSize, err := strconv.Atoi(pageSize)
+	if err == nil {
+		intSize, err = pageLimit(intSize)
+	}
+	if err != nil {
+		log.Debug(f+"invalid page size: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid size parameter", err)
+		return
+	}
+	intPage, err := strconv.Atoi(pageNum)
+	if err == nil {
+		err = pageCheck(intPage)
+	}
+	if err != nil {
+		log.Debug(f+"invalid page number: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid page parameter", err)
+		return
+	}
+	values := make(map[string]string, len(dateParams))
+	for _, name := range dateParams {
+		values[name] = c.Query(name)
+	}
+	dates, err := parseDates(values)
+	if err != nil {
+		log.Debug(f+"invalid date range: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid date range", err)
+		return
+	}
+	var filter *models.Filter
+	if filterParam != "" {
+		filter, err = models.ParseFilter(filterParam)
+		if err != nil {
+			log.Debug(f+"invalid structured filter: ", err)
+			sendError(c, 400, models.CodeBadRequest, "Invalid filter", err)
+			return
+		}
+	}
+	query, err := entriesQuery(
+		intSize,
+		intPage,
+		filterCol,
+		filterData,
+		filter,
+		sortCol,
+		dates,
+	)
+	if err != nil {
+		log.Debug(f+"invalid filter: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid filter", err)
+		return
+	}
+	var since time.Time
+	if sinceParam != "" {
+		since, err = time.Parse(time.RFC3339, sinceParam)
+		if err != nil {
+			log.Debug(f+"invalid since time: ", err)
+			sendError(
+				c, 400, models.CodeBadRequest, "Invalid since parameter", err,
+			)
+			return
+		}
+	}
+	sinceID, err := strconv.ParseUint(sinceIDParam, 10, 64)
+	if err != nil {
+		log.Debug(f+"invalid since ID: ", err)
+		sendError(
+			c, 400, models.CodeBadRequest, "Invalid since_id parameter", err,
+		)
+		return
+	}
+	deleted, err := strconv.ParseBool(deletedParam)
+	if err != nil {
+		log.Debug(f+"invalid include_deleted flag: ", err)
+		sendError(
+			c,
+			400,
+			models.CodeBadRequest,
+			"Invalid include_deleted parameter",
+			err,
+		)
+		return
+	}
+	query = query.WithContext(c.Request.Context())
+	if sinceParam != "" || deleted {
+		readChanges(c, query, intSize, since, uint(sinceID), deleted)
+		return
+	}
+	var entries []models.Entry
+	cacheKey := entriesKey(
+		c.Request.Context(),
+		intSize,
+		intPage,
+		filterCol,
+		filterData,
+		filter,
+		sortCol,
+		dates,
+	)
+	log.WithFields(logrus.Fields{
+		"Key": cacheKey,
+	}).Debug(f + "Redis cache key")
+	if cached(c.Request.Context(), f, cacheKey, &entries) {
+		log.Info(f + "data from CACHE")
+		cacheStats.record(true)
+		cacheControl(c)
+		maskFor(c, entries)
+		respond(c, 200, gin.H{"entries": entries})
+		return
+	}
+	cacheStats.record(false)
+	err = query.Find(&entries).Error
+	if cancelled(c) {
+		log.Debug(f+"request cancelled: ", err)
+		return
+	}
+	if err != nil {
+		log.Error(f+"request to the database failed: ", err)
+		sendError(c, 500, models.CodeInternal, "Request failed", nil)
+		return
+	}
+	log.Info(f + "data from DATABASE")
+	cacheEntries(c.Request.Context(), f, cacheKey, entries)
+	cacheControl(c)
+	maskFor(c, entries)
+	respond(c, 200, gin.H{"entries": entries})
+}
+
+// The change time of an entry: its deletion time for the tombstones and
+// its update time otherwise. GREATEST ignores the NULL deletion time.
+const changedAt = "GREATEST(updated_at, deleted_at)"
+
+// The function returns the entries changed after the cursor of the change
+// time and ID for the incremental sync, with the tombstones of the
+// soft-deleted entries if requested. The changes are ordered by the cursor
+// and paged by its keyset instead of the page number. The full page
+// returns its last change as the next cursor, otherwise the server
+// timestamp taken before the query is returned with the zero ID, so
+// concurrent changes are not missed.
+func readChanges(
+	c *gin.Context,
+	query *gorm.DB,
+	size int,
+	since time.Time,
+	sinceID uint,
+	deleted bool,
+) {
+	f := logging.F()
+	now := time.Now().UTC()
+	if deleted {
+		query = query.Unscoped()
+	}
+	if !since.IsZero() {
+		query = query.Where(
+			"("+changedAt+" > ? OR ("+changedAt+" = ? AND id > ?))",
+			since,
+			since,
+			sinceID,
+		)
+	}
+	query = query.Offset(-1).Order(clause.OrderByColumn{
+		Column:  clause.Column{Name: changedAt, Raw: true},
+		Reorder: true,
+	}).Order("id")
+	var entries []models.Entry
+	err := query.Find(&entries).Error
+	if err != nil {
+		log.Error(f+"request to the database failed: ", err)
+		sendError(c, 500, models.CodeInternal, "Request failed", nil)
+		return
+	}
+	log.Info(f + "changes from DATABASE")
+	var nextID uint
+	if len(entries) == size {
+		last := entries[len(entries)-1]
+		now, nextID = last.UpdatedAt.UTC(), last.ID
+		if last.DeletedAt.Valid && last.DeletedAt.Time.After(now) {
+			now = last.DeletedAt.Time.UTC()
+		}
+	}
+	c.Header("Cache-Control", "no-store")
+	maskFor(c, entries)
+	respond(c, 200, gin.H{
+		"entries":   entries,
+		"timestamp": now.Format(time.RFC3339Nano),
+		"since_id":  nextID,
+	})
+}
+
+// The function reads the default and the maximum page sizes from the
+// PAGE_SIZE and PAGE_SIZE_MAX environment variables.
+func pageLimits() (def int, max int, err error) {
+	def, max = 10, 100
+	if value := os.Getenv("PAGE_SIZE"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid PAGE_SIZE %q", value)
+		}
+		def = n
+	}
+	if value := os.Getenv("PAGE_SIZE_MAX"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid PAGE_SIZE_MAX %q", value)
+		}
+		max = n
+	}
+	return def, max, nil
+}
+
+// The function resolves the requested page size of the REST and GraphQL
+// reading. Zero size is replaced with the default from PAGE_SIZE, the
+// size above PAGE_SIZE_MAX or negative returns an error.
+func pageLimit(size int) (int, error) {
+	def, max, err := pageLimits()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case size < 0:
+		return 0, errors.New("size must be positive")
+	case size == 0:
+		size = def
+	}
+	if size > max {
+		return 0, fmt.Errorf("size exceeds the maximum of %d", max)
+	}
+	return size, nil
+}
+
+// The function checks the requested page number of the REST and
+// GraphQL reading, pages are counted from one.
+func pageCheck(page int) error {
+	if page < 1 {
+		return errors.New("page must be positive")
+	}
+	return nil
+}
+
+// The function builds the database query of the entries page with the
+// whitelisted "col" and "data" or the structured filter and sorting,
+// SORT_DEFAULT if no sort is requested, otherwise returns an error. The
+// pages are always ordered by the ID in the end to be stable.
+func entriesQuery(
+	size int,
+	page int,
+	col string,
+	data string,
+	filter *models.Filter,
+	sort string,
+	dates dateFilter,
+) (*gorm.DB, error) {
+	query := db.C.Model(&models.Entry{}).
+		Limit(size).
+		Offset((page - 1) * size)
+	query = dates.apply(query)
+	if col != "" {
+		column, ok := models.FilterColumn(col)
+		if !ok {
+			return nil, fmt.Errorf(`column "%s" is not filterable`, col)
+		}
+		clause, arg, err := column.Where(data)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(clause, arg)
+	}
+	if filter != nil {
+		clause, args := filter.Where()
+		query = query.Where(clause, args...)
+	}
+	if sort == "" {
+		sort = os.Getenv("SORT_DEFAULT")
+	}
+	if sort != "" {
+		order, err := models.SortOrder(sort)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Order(order)
+	}
+	// The ID breaks the ties of the sort, so the pages neither repeat
+	// nor skip the rows with the equal values.
+	if !strings.EqualFold(strings.TrimPrefix(sort, "-"), "id") {
+		query = query.Order("id")
+	}
+	return query, nil
+}
+
+// The date range filter of the entries by the creation and the update
+// timestamps. Zero bounds are not applied.
+type dateFilter struct {
+	createdAfter  time.Time
+	createdBefore time.Time
+	updatedAfter  time.Time
+	updatedBefore time.Time
+}
+
+// The names of the date range parameters of the REST and GraphQL
+// reading.
+var dateParams = []string{
+	"created_after",
+	"created_before",
+	"updated_after",
+	"updated_before",
+}
+
+// The function parses the RFC3339 bounds of the date range filter by
+// the parameter names, otherwise returns an error for the malformed date
+// or the inverted range.
+func parseDates(values map[string]string) (dateFilter, error) {
+	var dates dateFilter
+	bounds := []*time.Time{
+		&dates.createdAfter,
+		&dates.createdBefore,
+		&dates.updatedAfter,
+		&dates.updatedBefore,
+	}
+	for i, name := range dateParams {
+		if values[name] == "" {
+			continue
+		}
+		bound, err := time.Parse(time.RFC3339, values[name])
+		if err != nil {
+			return dateFilter{}, fmt.Errorf(
+				`"%s" is not an RFC3339 date`, name,
+			)
+		}
+		*bounds[i] = bound
+	}
+	if inverted(dates.createdAfter, dates.createdBefore) {
+		return dateFilter{}, errors.New("created_after exceeds created_before")
+	}
+	if inverted(dates.updatedAfter, dates.updatedBefore) {
+		return dateFilter{}, errors.New("updated_after exceeds updated_before")
+	}
+	return dates, nil
+}
+
+// The function reports whether both bounds are set and the lower one is
+// after the upper one.
+func inverted(after time.Time, before time.Time) bool {
+	return !after.IsZero() && !before.IsZero() && after.After(before)
+}
+
+// The method adds the set bounds to the query. The lower bounds are
+// inclusive, the upper ones are exclusive.
+func (d dateFilter) apply(query *gorm.DB) *gorm.DB {
+	if !d.createdAfter.IsZero() {
+		query = query.Where("created_at >= ?", d.createdAfter)
+	}
+	if !d.createdBefore.IsZero() {
+		query = query.Where("created_at < ?", d.createdBefore)
+	}
+	if !d.updatedAfter.IsZero() {
+		query = query.Where("updated_at >= ?", d.updatedAfter)
+	}
+	if !d.updatedBefore.IsZero() {
+		query = query.Where("updated_at < ?", d.updatedBefore)
+	}
+	return query
+}
+
+// The method returns the part of the cache key for the date range.
+func (d dateFilter) key() string {
+	bounds := []time.Time{
+		d.createdAfter,
+		d.createdBefore,
+		d.updatedAfter,
+		d.updatedBefore,
+	}
+	parts := make([]string, len(bounds))
+	for i, bound := range bounds {
+		if !bound.IsZero() {
+			parts[i] = strconv.FormatInt(bound.UnixNano(), 10)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// This API handler returns the whitelisted columns of the entries with
+// their types and operators available for filtering and sorting.
+func Fields(c *gin.Context) {
+	respond(c, 200, gin.H{"fields": models.Columns})
+}
+
+// This API handler reads the entry ID from the path and returns the
+// provenance of its enriched fields. Return a JSON message with data or
+// an error with its cause.
+func Provenance(c *gin.Context) {
+	f := logging.F()
+	id := c.Param("id")
+	cond, arg, err := models.EntryCond(id)
 	if err != nil {
-		log.Debug(f+"invalid page size: ", err)
-		c.JSON(400, gin.H{"error": "Invalid size parameter"})
+		log.Debug(f+"invalid entry ID: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid ID parameter", err)
 		return
 	}
-	intPage, err := strconv.Atoi(pageNum)
+	log.WithFields(logrus.Fields{
+		"ID": id,
+	}).Debug(f + "provenance ID")
+	var entry models.Entry
+	err = db.C.WithContext(c.Request.Context()).
+		Preload("Provenance").
+		First(&entry, cond, arg).
+		Error
 	if err != nil {
-		log.Debug(f+"invalid page number: ", err)
-		c.JSON(400, gin.H{"error": "Invalid page parameter"})
+		sendError(
+			c,
+			404,
+			models.CodeNotFound,
+			fmt.Sprintf(`Entry "%v" does not exist`, id),
+			nil,
+		)
 		return
 	}
-	offset := (intPage - 1) * intSize
-	var entries []models.Entry
-	cacheKey := fmt.Sprintf(
-		"entries:%v:%v:%s:%s", intSize, intPage, filterCol, filterData,
-	)
+	respond(c, 200, gin.H{"provenance": entry.Provenance})
+}
+
+// This API handler reads the name from the query and returns the data
+// derived by the enrichment providers with its provenance without
+// saving to the database. Return a JSON message with data or an error
+// with its cause.
+func EnrichPreview(c *gin.Context) {
+	f := logging.F()
+	name := c.Query("name")
 	log.WithFields(logrus.Fields{
-		"Key": cacheKey,
-	}).Debug(f + "Redis cache key")
-	cacheResult, err := cRedis.Get(ctx, cacheKey).Result()
-	if err == nil {
-		err := json.Unmarshal([]byte(cacheResult), &entries)
-		if err != nil {
-			log.Error(f+"JSON deserializing failed: ", err)
-		}
-		log.Info(f + "data from CACHE")
-		c.JSON(200, gin.H{"entries": entries})
+		"Name": name,
+	}).Debug(f + "preview name")
+	if name == "" {
+		sendError(c, 400, models.CodeBadRequest, `Fill in the "name"`, nil)
 		return
 	}
-	log.Debug(f+"cache error: ", err)
-	switch {
-	case filterCol != "" && filterData != "":
-		err = db.C.Model(&models.Entry{}).
-			Limit(intSize).
-			Offset(offset).
-			Where(filterCol+" LIKE ?", "%"+filterData+"%").
-			Find(&entries).
-			Error
-	default:
-		err = db.C.Model(&models.Entry{}).
-			Limit(intSize).
-			Offset(offset).
-			Find(&entries).
-			Error
-	}
+	var entry models.Entry
+	err := entry.EnrichContext(c.Request.Context(), name)
 	if err != nil {
-		log.Error(f+"request to the database failed: ", err)
-		c.JSON(500, gin.H{"error": "Request failed"})
+		log.Debug(f+"failed to enrich data from API: ", err)
+		sendError(
+			c,
+			502,
+			models.CodeProviderFailed,
+			"Failed to enrich data from API",
+			err,
+		)
 		return
 	}
-	log.Info(f + "data from DATABASE")
-	jsonData, err := json.Marshal(entries)
+	c.JSON(200, gin.H{
+		"name":        name,
+		"age":         entry.Age,
+		"gender":      entry.Gender,
+		"nationality": entry.Nationality,
+		"provenance":  entry.Provenance,
+	})
+}
+
+// The function splits the "where" precondition of the update from the
+// JSON request body, so the rest is bound as the Entry model. Returns
+// the validated expected column values, otherwise an error.
+func splitWhere(c *gin.Context) (map[string]interface{}, error) {
+	if c.ContentType() != binding.MIMEJSON {
+		return nil, nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
-		log.Error(f+"serializing to JSON failed: ", err)
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return nil, nil
+	}
+	raw, ok := fields["where"]
+	if !ok {
+		return nil, nil
+	}
+	delete(fields, "where")
+	body, err = json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	var where map[string]interface{}
+	if err := json.Unmarshal(raw, &where); err != nil {
+		return nil, err
+	}
+	if len(where) == 0 {
+		return nil, nil
+	}
+	return models.ValidUpdates(where)
+}
+
+// The function reports whether the field is supplied in the JSON or the
+// form body of the request, matching its name case-insensitively like
+// the binding. The body is kept for the binding.
+func supplied(c *gin.Context, field string) bool {
+	var keys []string
+	if c.ContentType() == binding.MIMEJSON {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return false
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(body, &fields) != nil {
+			return false
+		}
+		for key := range fields {
+			keys = append(keys, key)
+		}
+	} else {
+		c.Request.ParseMultipartForm(32 << 20)
+		for key := range c.Request.PostForm {
+			keys = append(keys, key)
+		}
 	}
-	cRedis.Set(ctx, cacheKey, jsonData, 0)
-	c.JSON(200, gin.H{"entries": entries})
+	for _, key := range keys {
+		if strings.EqualFold(key, field) {
+			return true
+		}
+	}
+	return false
 }
 
 // This API handler checks the input data, updates the record into the
-// database and dumps the Redis cache keys. Return a JSON success
-// message or an error with its cause.
+// database and dumps the Redis cache keys. The "where" object of the
+// expected current values makes the update conditional, the mismatch
+// returns 409. The verified flag is kept unless supplied. Return a JSON
+// success message or an error with its cause.
 func Update(c *gin.Context) {
 	f := logging.F()
+	where, err := splitWhere(c)
+	if err != nil {
+		log.Debug(f+"invalid precondition: ", err)
+		sendError(
+			c, 400, models.CodeBadRequest, "Invalid where precondition", err,
+		)
+		return
+	}
+	verified := supplied(c, "verified")
 	var updEntry models.Entry
-	if err := c.ShouldBind(&updEntry); err != nil {
+	if err := bindEntry(c, &updEntry); err != nil {
 		log.Debug(f+"parsing failed: ", err)
-		c.JSON(400, gin.H{"error": "Invalid API query"})
+		sendBindError(c, err)
 		return
 	}
+	updEntry.Normalize()
 	log.WithFields(logrus.Fields{
 		"ID":          updEntry.ID,
 		"Name":        updEntry.Name,
@@ -278,39 +1274,63 @@ func Update(c *gin.Context) {
 		"Age":         updEntry.Age,
 		"Gender":      updEntry.Gender,
 		"Nationality": updEntry.Nationality,
+		"Verified":    updEntry.Verified,
 	}).Debug(f + "updEntry")
-	err := updEntry.IsValid()
+	cond, arg, err := models.EntryCond(updEntry.PublicID())
 	if err != nil {
-		c.JSON(422, gin.H{"error": fmt.Sprintf("Filling errors: %v", err)})
+		log.Debug(f+"invalid entry ID: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid ID parameter", err)
 		return
 	}
-	err = db.C.Model(&models.Entry{}).
-		Where("id = ?", updEntry.ID).
-		Updates(map[string]interface{}{
-			"name":        updEntry.Name,
-			"surname":     updEntry.Surname,
-			"patronymic":  updEntry.Patronymic,
-			"age":         updEntry.Age,
-			"gender":      updEntry.Gender,
-			"nationality": updEntry.Nationality,
-		}).
-		Error
+	err = updEntry.IsValid()
 	if err != nil {
-		c.JSON(
-			404,
-			gin.H{"message": fmt.Sprintf(
-				`Entry "%v" does not exist`,
-				updEntry.ID,
-			)},
-		)
+		sendError(c, 422, models.CodeValidationFailed, "Filling errors", err)
 		return
 	}
-	status, err := cRedis.FlushAll(ctx).Result()
-	if err != nil {
-		log.Error(f+"FLUSHALL failed: ", err)
+	fields := map[string]interface{}{
+		"name":        updEntry.Name,
+		"surname":     updEntry.Surname,
+		"patronymic":  updEntry.Patronymic,
+		"age":         updEntry.Age,
+		"gender":      updEntry.Gender,
+		"nationality": updEntry.Nationality,
+	}
+	if verified {
+		fields["verified"] = updEntry.Verified
+	}
+	if where == nil {
+		err = store.Update(c.Request.Context(), cond, arg, fields)
 	} else {
-		log.Debug(f+"FLUSHALL success: ", status)
+		var updated int64
+		updated, err = store.UpdateIf(
+			c.Request.Context(), cond, arg, where, fields,
+		)
+		if err == nil && updated == 0 {
+			var entry models.Entry
+			if store.First(c.Request.Context(), &entry, cond, arg) == nil {
+				sendError(
+					c,
+					409,
+					models.CodeConflict,
+					"Entry does not match the precondition",
+					nil,
+				)
+				return
+			}
+			err = gorm.ErrRecordNotFound
+		}
+	}
+	if err != nil {
+		sendError(
+			c,
+			404,
+			models.CodeNotFound,
+			fmt.Sprintf(`Entry "%v" does not exist`, updEntry.PublicID()),
+			nil,
+		)
+		return
 	}
+	invalidateCache(f)
 	c.JSON(200, gin.H{"message": "Success"})
 }
 
@@ -322,80 +1342,257 @@ func Delete(c *gin.Context) {
 	var delEntry models.Entry
 	if err := c.ShouldBind(&delEntry); err != nil {
 		log.Debug(f+"parsing failed: ", err)
-		c.JSON(400, gin.H{"error": "Invalid API query"})
+		sendError(c, 400, models.CodeBadRequest, "Invalid API query", err)
 		return
 	}
 	log.WithFields(logrus.Fields{
-		"ID": delEntry.ID,
+		"ID":   delEntry.ID,
+		"UUID": delEntry.UUID,
 	}).Debug(f + "delEntry")
+	cond, arg, err := models.EntryCond(delEntry.PublicID())
+	if err != nil {
+		log.Debug(f+"invalid entry ID: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid ID parameter", err)
+		return
+	}
 	var entry models.Entry
-	err := db.C.First(&entry, "id = ?", delEntry.ID).Error
+	err = store.First(c.Request.Context(), &entry, cond, arg)
 	if err != nil {
-		c.JSON(
+		sendError(
+			c,
 			404,
-			gin.H{"message": fmt.Sprintf(
-				`Entry "%v" does not exist`,
-				delEntry.ID,
-			)},
+			models.CodeNotFound,
+			fmt.Sprintf(`Entry "%v" does not exist`, delEntry.PublicID()),
+			nil,
 		)
 		return
 	}
-	err = db.C.Unscoped().Delete(&entry).Error
+	err = store.Delete(c.Request.Context(), &entry)
 	if err != nil {
 		log.Error(f+"failed to delete entry: ", err)
-		c.JSON(500, gin.H{"error": "Failed to delete entry"})
+		sendError(c, 500, models.CodeInternal, "Failed to delete entry", nil)
 		return
 	}
-	status, err := cRedis.FlushAll(ctx).Result()
-	if err != nil {
-		log.Error(f+"FLUSHALL failed: ", err)
-	} else {
-		log.Debug(f+"FLUSHALL success: ", status)
-	}
+	invalidateCache(f)
 	c.JSON(200, gin.H{"message": "Success"})
 }
 
-// The main GraphQL handler. Reads the query data and performs
-// operations in accordance with the scheme. Return a JSON message with
-// data or an error with its cause.
+// The main GraphQL handler. Reads the query data or the persisted query
+// hash and performs operations in accordance with the scheme. Return a
+// JSON message with data and resolver errors, or 400 with the errors of
+// a malformed query.
 func GraphQL(c *gin.Context) {
 	f := logging.F()
-	var req struct {
-		Query string `json:"query"`
-	}
+	var req graphqlRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		log.Debug(f+"parsing failed: ", err)
-		c.JSON(400, gin.H{"error": "Invalid GraphQL query"})
+		sendError(c, 400, models.CodeBadRequest, "Invalid GraphQL query", err)
+		return
+	}
+	query, ok := persistedQuery(c, req)
+	if !ok {
+		return
+	}
+	if schemaErr != nil || schema.QueryType() == nil {
+		log.Error(f+"GraphQL schema is not available: ", schemaErr)
+		sendError(
+			c, 500, models.CodeInternal, "GraphQL schema is not available", nil,
+		)
 		return
 	}
 	result := graphql.Do(graphql.Params{
-		Schema:        schema,
-		RequestString: req.Query,
+		Schema:         schema,
+		RequestString:  query,
+		VariableValues: req.Variables,
+		Context: context.WithValue(
+			context.WithValue(
+				context.WithValue(c.Request.Context(), adminKey, isAdmin(c)),
+				unmaskedKey,
+				unmasked(c),
+			),
+			nodesKey,
+			new(int64),
+		),
 	})
 	if len(result.Errors) > 0 {
-		c.JSON(400, gin.H{"errors": result.Errors})
+		for _, err := range result.Errors {
+			if isReadOnlyErr(err) {
+				c.JSON(503, gin.H{"errors": result.Errors})
+				return
+			}
+		}
+		if malformed(result) {
+			c.JSON(400, gin.H{"errors": result.Errors})
+			return
+		}
+		c.JSON(200, gin.H{"data": result.Data, "errors": result.Errors})
 		return
 	}
 	c.JSON(200, gin.H{"data": result.Data})
 }
 
-// The processing scheme of root queries.
-var schema, _ = graphql.NewSchema(graphql.SchemaConfig{
-	Query:    rootQuery,
-	Mutation: rootMutation,
-})
+// The function reports whether the GraphQL result failed before the
+// execution, i.e. the query could not be parsed or validated. Resolver
+// errors always carry the path of the failed field.
+func malformed(result *graphql.Result) bool {
+	if result.Data != nil {
+		return false
+	}
+	for _, err := range result.Errors {
+		if len(err.Path) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type ctxKey string
+
+// The context key of the administrator access flag.
+const adminKey ctxKey = "admin"
+
+// The context key of the node counter of the GraphQL request.
+const nodesKey ctxKey = "nodes"
+
+// The function counts the entries returned by the GraphQL request and
+// returns an error when their total exceeds GRAPHQL_MAX_NODES, so that
+// aliased queries can not bypass the page size limit.
+func takeNodes(ctx context.Context, n int) error {
+	counter, ok := ctx.Value(nodesKey).(*int64)
+	if !ok {
+		return nil
+	}
+	max := int64(1000)
+	if value := os.Getenv("GRAPHQL_MAX_NODES"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 {
+			return fmt.Errorf("invalid GRAPHQL_MAX_NODES %q", value)
+		}
+		max = parsed
+	}
+	if atomic.AddInt64(counter, int64(n)) > max {
+		return fmt.Errorf("result exceeds the maximum of %d nodes", max)
+	}
+	return nil
+}
+
+// The function checks the bearer token of the request against the
+// administrator token from the environment variables.
+func isAdmin(c *gin.Context) bool {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return false
+	}
+	header := []byte(c.GetHeader("Authorization"))
+	return subtle.ConstantTimeCompare(header, []byte("Bearer "+token)) == 1
+}
+
+// The processing scheme of root queries and the error of its building.
+var schema, schemaErr = BuildSchema(rootQuery, rootMutation)
+
+// The function builds the GraphQL schema from the root query and
+// mutation objects, otherwise returns an error with its cause.
+func BuildSchema(
+	query *graphql.Object,
+	mutation *graphql.Object,
+) (graphql.Schema, error) {
+	built, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:    query,
+		Mutation: mutation,
+	})
+	if err != nil {
+		return built, fmt.Errorf("failed to build GraphQL schema: %w", err)
+	}
+	return built, nil
+}
+
+// The function returns the error of the GraphQL schema building to
+// stop the server at startup instead of failing every request.
+func SchemaErr() error {
+	return schemaErr
+}
 
 // GraphQL data fields for the Entry model.
 var entryType = graphql.NewObject(graphql.ObjectConfig{
 	Name: "Entry",
 	Fields: graphql.Fields{
-		"ID":          &graphql.Field{Type: graphql.Int},
+		"ID":          &graphql.Field{Type: graphql.Int, Resolve: integerID},
 		"Name":        &graphql.Field{Type: graphql.String},
 		"Surname":     &graphql.Field{Type: graphql.String},
-		"Patronymic":  &graphql.Field{Type: graphql.String},
-		"Age":         &graphql.Field{Type: graphql.Int},
-		"Gender":      &graphql.Field{Type: graphql.String},
-		"Nationality": &graphql.Field{Type: graphql.String},
+		"Patronymic":  &graphql.Field{Type: graphql.String, Resolve: optional},
+		"Age":         &graphql.Field{Type: graphql.Int, Resolve: optional},
+		"Gender":      &graphql.Field{Type: graphql.String, Resolve: optional},
+		"Nationality": &graphql.Field{Type: graphql.String, Resolve: optional},
+		"Source":      &graphql.Field{Type: graphql.String, Resolve: optional},
+		"UUID":        &graphql.Field{Type: graphql.String, Resolve: optional},
+		"Verified":    &graphql.Field{Type: graphql.Boolean},
+	},
+})
+
+// The resolver of the integer ID of the Entry, hidden in the UUID mode.
+func integerID(p graphql.ResolveParams) (interface{}, error) {
+	if models.IDType() == models.IDUUID {
+		return nil, nil
+	}
+	return graphql.DefaultResolveFn(p)
+}
+
+// The resolver of the optional Entry field. The zero value of the absent
+// data, like the missing patronymic or the unknown age, is resolved to
+// null instead of the empty string or 0.
+func optional(p graphql.ResolveParams) (interface{}, error) {
+	value, err := graphql.DefaultResolveFn(p)
+	if err != nil || value == nil || reflect.ValueOf(value).IsZero() {
+		return nil, err
+	}
+	return value, nil
+}
+
+// The GraphQL scalar of the age input accepting the Int and the Float
+// literals and variables. The number is checked by models.ParseAge() in
+// the resolvers, so a fractional age gets its error instead of the type
+// mismatch.
+var ageScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Age",
+	Description: "The age as an integral number.",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		switch value := value.(type) {
+		case int:
+			return float64(value)
+		case float64:
+			return value
+		}
+		return nil
+	},
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		switch valueAST := valueAST.(type) {
+		case *ast.IntValue, *ast.FloatValue:
+			number, err := strconv.ParseFloat(
+				valueAST.GetValue().(string), 64,
+			)
+			if err != nil {
+				return nil
+			}
+			return number
+		}
+		return nil
+	},
+})
+
+// GraphQL input fields for the partial update of the Entry model.
+var entryInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "EntryInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"name":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"surname":     &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"patronymic":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"age":         &graphql.InputObjectFieldConfig{Type: ageScalar},
+		"gender":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"nationality": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"verified":    &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
 	},
 })
 
@@ -408,7 +1605,7 @@ var rootQuery = graphql.NewObject(graphql.ObjectConfig{
 			Args: graphql.FieldConfigArgument{
 				"size": &graphql.ArgumentConfig{
 					Type:         graphql.Int,
-					DefaultValue: 10,
+					DefaultValue: 0,
 				},
 				"page": &graphql.ArgumentConfig{
 					Type:         graphql.Int,
@@ -422,6 +1619,22 @@ var rootQuery = graphql.NewObject(graphql.ObjectConfig{
 					Type:         graphql.String,
 					DefaultValue: "",
 				},
+				"sort": &graphql.ArgumentConfig{
+					Type:         graphql.String,
+					DefaultValue: "",
+				},
+				"created_after": &graphql.ArgumentConfig{
+					Type: graphql.String,
+				},
+				"created_before": &graphql.ArgumentConfig{
+					Type: graphql.String,
+				},
+				"updated_after": &graphql.ArgumentConfig{
+					Type: graphql.String,
+				},
+				"updated_before": &graphql.ArgumentConfig{
+					Type: graphql.String,
+				},
 			},
 			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 				f := logging.F()
@@ -429,48 +1642,73 @@ var rootQuery = graphql.NewObject(graphql.ObjectConfig{
 				intPage, _ := p.Args["page"].(int)
 				filterCol, _ := p.Args["col"].(string)
 				filterData, _ := p.Args["data"].(string)
+				sortCol, _ := p.Args["sort"].(string)
 				switch {
 				case filterCol != "" && filterData == "":
 					fallthrough
 				case filterCol == "" && filterData != "":
 					return nil, errors.New(`fill in both "col" and "data"`)
 				}
-				offset := (intPage - 1) * intSize
+				_, max, err := pageLimits()
+				if err != nil {
+					return nil, err
+				}
+				if intSize > max {
+					log.Debug(f+"size clamped to ", max)
+					intSize = max
+				}
+				intSize, err = pageLimit(intSize)
+				if err != nil {
+					return nil, err
+				}
+				err = pageCheck(intPage)
+				if err != nil {
+					return nil, err
+				}
+				values := make(map[string]string, len(dateParams))
+				for _, name := range dateParams {
+					values[name], _ = p.Args[name].(string)
+				}
+				dates, err := parseDates(values)
+				if err != nil {
+					return nil, err
+				}
+				query, err := entriesQuery(
+					intSize,
+					intPage,
+					filterCol,
+					filterData,
+					nil,
+					sortCol,
+					dates,
+				)
+				if err != nil {
+					return nil, err
+				}
 				var entries []models.Entry
-				cacheKey := fmt.Sprintf(
-					"entries:%v:%v:%s:%s",
+				cacheKey := entriesKey(
+					p.Context,
 					intSize,
 					intPage,
 					filterCol,
 					filterData,
+					nil,
+					sortCol,
+					dates,
 				)
 				log.WithFields(logrus.Fields{
 					"Key": cacheKey,
 				}).Debug(f + "Redis cache key")
-				cacheResult, err := cRedis.Get(ctx, cacheKey).Result()
-				if err == nil {
-					err := json.Unmarshal([]byte(cacheResult), &entries)
-					if err != nil {
-						log.Error(f+"JSON deserializing failed: ", err)
-					}
+				if cached(p.Context, f, cacheKey, &entries) {
 					log.Info(f + "data from CACHE")
-					return entries, nil
-				}
-				switch {
-				case filterCol != "" && filterData != "":
-					err = db.C.Model(&models.Entry{}).
-						Limit(intSize).
-						Offset(offset).
-						Where(filterCol+" LIKE ?", "%"+filterData+"%").
-						Find(&entries).
-						Error
-				default:
-					err = db.C.Model(&models.Entry{}).
-						Limit(intSize).
-						Offset(offset).
-						Find(&entries).
-						Error
+					cacheStats.record(true)
+					if !unmaskedCtx(p.Context) {
+						maskEntries(entries)
+					}
+					return entries, takeNodes(p.Context, len(entries))
 				}
+				cacheStats.record(false)
+				err = query.WithContext(p.Context).Find(&entries).Error
 				if err != nil {
 					log.Error(
 						f+"request to the database failed: ",
@@ -479,17 +1717,144 @@ var rootQuery = graphql.NewObject(graphql.ObjectConfig{
 					return nil, err
 				}
 				log.Info(f + "data from DATABASE")
-				jsonData, err := json.Marshal(entries)
+				cacheEntries(p.Context, f, cacheKey, entries)
+				if !unmaskedCtx(p.Context) {
+					maskEntries(entries)
+				}
+				return entries, takeNodes(p.Context, len(entries))
+			},
+		},
+		"entriesByIds": &graphql.Field{
+			Type: graphql.NewList(entryType),
+			Args: graphql.FieldConfigArgument{
+				"ids": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(
+						graphql.NewList(graphql.NewNonNull(graphql.ID)),
+					),
+				},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				f := logging.F()
+				args, _ := p.Args["ids"].([]interface{})
+				list := make([]string, len(args))
+				for i, id := range args {
+					list[i] = fmt.Sprint(id)
+				}
+				entries, err := entriesByIDs(p.Context, f, list)
 				if err != nil {
-					log.Error(f+"serializing to JSON failed: ", err)
+					return nil, err
 				}
-				cRedis.Set(ctx, cacheKey, jsonData, 0)
-				return entries, nil
+				if !unmaskedCtx(p.Context) {
+					maskEntries(entries)
+				}
+				return entries, takeNodes(p.Context, len(entries))
 			},
 		},
 	},
 })
 
+// The error of the IDs not accepted by the entriesByIDs() function.
+var errInvalidIDs = errors.New("invalid ids")
+
+// The function reads the entries of the comma separated public IDs for
+// the Read() handler. Return a JSON message with the entries in the
+// order of the IDs or an error with its cause.
+func readIDs(c *gin.Context, idsParam string) {
+	f := logging.F()
+	list := strings.Split(idsParam, ",")
+	entries, err := entriesByIDs(c.Request.Context(), f, list)
+	if cancelled(c) {
+		log.Debug(f+"request cancelled: ", err)
+		return
+	}
+	if errors.Is(err, errInvalidIDs) {
+		log.Debug(f+"invalid entry IDs: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid ids parameter", err)
+		return
+	}
+	if err != nil {
+		sendError(c, 500, models.CodeInternal, "Request failed", nil)
+		return
+	}
+	cacheControl(c)
+	maskFor(c, entries)
+	respond(c, 200, gin.H{"entries": entries})
+}
+
+// The function reads the entries by the public IDs of the current mode
+// in one query with the cache by the sorted ID set. The entries are
+// returned in the order of the IDs without the missing and repeated
+// ones, otherwise an error.
+//
+// The IN query returns the rows in an arbitrary order. Postgres could
+// keep the order with "ORDER BY array_position(?, id)", but the rows
+// are reordered by the IDs after the fetch instead, which works with
+// any GORM driver and with the cached sets shared by the permutations.
+func entriesByIDs(
+	ctx context.Context,
+	f string,
+	list []string,
+) ([]models.Entry, error) {
+	_, max, err := pageLimits()
+	if err != nil {
+		return nil, err
+	}
+	if len(list) > max {
+		return nil, fmt.Errorf(
+			"%w: exceed the maximum of %d", errInvalidIDs, max,
+		)
+	}
+	var ids []string
+	seen := make(map[string]bool, len(list))
+	for _, item := range list {
+		_, arg, err := models.EntryCond(strings.TrimSpace(item))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidIDs, err)
+		}
+		id := fmt.Sprint(arg)
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return []models.Entry{}, nil
+	}
+	sorted := append([]string{}, ids...)
+	sort.Strings(sorted)
+	cacheKey := nsKey(fmt.Sprintf(
+		"entries:%v:ids:%s",
+		generation(ctx),
+		strings.Join(sorted, ","),
+	))
+	var found []models.Entry
+	if cached(ctx, f, cacheKey, &found) {
+		log.Info(f + "data from CACHE")
+		cacheStats.record(true)
+	} else {
+		cacheStats.record(false)
+		cond, arg, _ := models.EntriesCond(ids)
+		err = store.Find(ctx, &found, cond, arg)
+		if err != nil {
+			log.Error(f+"request to the database failed: ", err)
+			return nil, err
+		}
+		log.Info(f + "data from DATABASE")
+		cacheEntries(ctx, f, cacheKey, found)
+	}
+	byID := make(map[string]models.Entry, len(found))
+	for _, entry := range found {
+		byID[entry.PublicID()] = entry
+	}
+	entries := make([]models.Entry, 0, len(found))
+	for _, id := range ids {
+		if entry, ok := byID[id]; ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
 // The parameters of the root query for data changes and its handler.
 var rootMutation = graphql.NewObject(graphql.ObjectConfig{
 	Name: "RootMutation",
@@ -507,7 +1872,7 @@ var rootMutation = graphql.NewObject(graphql.ObjectConfig{
 					Type: graphql.String,
 				},
 				"age": &graphql.ArgumentConfig{
-					Type: graphql.NewNonNull(graphql.Int),
+					Type: graphql.NewNonNull(ageScalar),
 				},
 				"gender": &graphql.ArgumentConfig{
 					Type: graphql.NewNonNull(graphql.String),
@@ -518,20 +1883,28 @@ var rootMutation = graphql.NewObject(graphql.ObjectConfig{
 			},
 			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 				f := logging.F()
+				if readOnly() {
+					return nil, models.ErrReadOnly
+				}
 				name, _ := p.Args["name"].(string)
 				surname, _ := p.Args["surname"].(string)
 				patronymic, _ := p.Args["patronymic"].(string)
-				age, _ := p.Args["age"].(int)
+				number, _ := p.Args["age"].(float64)
 				gender, _ := p.Args["gender"].(string)
 				nationality, _ := p.Args["nationality"].(string)
+				age, err := models.ParseAge(number)
+				if err != nil {
+					return nil, err
+				}
 				newEntry := models.Entry{
 					Name:        name,
 					Surname:     surname,
 					Patronymic:  patronymic,
-					Age:         uint8(age),
+					Age:         age,
 					Gender:      gender,
 					Nationality: nationality,
 				}
+				newEntry.Normalize()
 				log.WithFields(logrus.Fields{
 					"Name":        newEntry.Name,
 					"Surname":     newEntry.Surname,
@@ -540,21 +1913,18 @@ var rootMutation = graphql.NewObject(graphql.ObjectConfig{
 					"Gender":      newEntry.Gender,
 					"Nationality": newEntry.Nationality,
 				}).Debug(f + "newEntry")
-				err := newEntry.IsValid()
+				err = newEntry.IsValid()
 				if err != nil {
 					return nil, err
 				}
-				err = db.C.Create(&newEntry).Error
+				err = store.Create(p.Context, &newEntry)
 				if err != nil {
-					log.Error(f+"failed to create entry: ", err)
+					log.WithFields(entryFields(newEntry)).
+						Error(f+"failed to create entry: ", err)
 					return nil, err
 				}
-				status, err := cRedis.FlushAll(ctx).Result()
-				if err != nil {
-					log.Error(f+"FLUSHALL failed: ", err)
-				} else {
-					log.Debug(f+"FLUSHALL success: ", status)
-				}
+				notifyCreated(newEntry)
+				invalidateCache(f)
 				return newEntry, nil
 			},
 		},
@@ -562,7 +1932,10 @@ var rootMutation = graphql.NewObject(graphql.ObjectConfig{
 			Type: entryType,
 			Args: graphql.FieldConfigArgument{
 				"id": &graphql.ArgumentConfig{
-					Type: graphql.NewNonNull(graphql.Int),
+					Type: graphql.Int,
+				},
+				"uuid": &graphql.ArgumentConfig{
+					Type: graphql.String,
 				},
 				"name": &graphql.ArgumentConfig{
 					Type: graphql.NewNonNull(graphql.String),
@@ -574,7 +1947,7 @@ var rootMutation = graphql.NewObject(graphql.ObjectConfig{
 					Type: graphql.NewNonNull(graphql.String),
 				},
 				"age": &graphql.ArgumentConfig{
-					Type: graphql.NewNonNull(graphql.Int),
+					Type: graphql.NewNonNull(ageScalar),
 				},
 				"gender": &graphql.ArgumentConfig{
 					Type: graphql.NewNonNull(graphql.String),
@@ -582,25 +1955,42 @@ var rootMutation = graphql.NewObject(graphql.ObjectConfig{
 				"nationality": &graphql.ArgumentConfig{
 					Type: graphql.NewNonNull(graphql.String),
 				},
+				"verified": &graphql.ArgumentConfig{
+					Type: graphql.Boolean,
+				},
 			},
 			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 				f := logging.F()
+				if readOnly() {
+					return nil, models.ErrReadOnly
+				}
 				id, _ := p.Args["id"].(int)
+				uid, _ := p.Args["uuid"].(string)
 				name, _ := p.Args["name"].(string)
 				surname, _ := p.Args["surname"].(string)
 				patronymic, _ := p.Args["patronymic"].(string)
-				age, _ := p.Args["age"].(int)
+				number, _ := p.Args["age"].(float64)
 				gender, _ := p.Args["gender"].(string)
 				nationality, _ := p.Args["nationality"].(string)
+				verified, setVerified := p.Args["verified"].(bool)
+				age, err := models.ParseAge(number)
+				if err != nil {
+					return nil, err
+				}
 				updEntry := models.Entry{
 					ID:          uint(id),
 					Name:        name,
 					Surname:     surname,
 					Patronymic:  patronymic,
-					Age:         uint8(age),
+					Age:         age,
 					Gender:      gender,
 					Nationality: nationality,
+					Verified:    verified,
 				}
+				if uid != "" {
+					updEntry.UUID = &uid
+				}
+				updEntry.Normalize()
 				log.WithFields(logrus.Fields{
 					"ID":          updEntry.ID,
 					"Name":        updEntry.Name,
@@ -609,68 +1999,134 @@ var rootMutation = graphql.NewObject(graphql.ObjectConfig{
 					"Age":         updEntry.Age,
 					"Gender":      updEntry.Gender,
 					"Nationality": updEntry.Nationality,
+					"Verified":    updEntry.Verified,
 				}).Debug(f + "updEntry")
-				err := updEntry.IsValid()
+				cond, arg, err := models.EntryCond(updEntry.PublicID())
 				if err != nil {
 					return nil, err
 				}
-				err = db.C.Model(&models.Entry{}).
-					Where("id = ?", updEntry.ID).
-					Updates(map[string]interface{}{
-						"name":        updEntry.Name,
-						"surname":     updEntry.Surname,
-						"patronymic":  updEntry.Patronymic,
-						"age":         updEntry.Age,
-						"gender":      updEntry.Gender,
-						"nationality": updEntry.Nationality,
-					}).
-					Error
+				err = updEntry.IsValid()
 				if err != nil {
 					return nil, err
 				}
-				status, err := cRedis.FlushAll(ctx).Result()
+				fields := map[string]interface{}{
+					"name":        updEntry.Name,
+					"surname":     updEntry.Surname,
+					"patronymic":  updEntry.Patronymic,
+					"age":         updEntry.Age,
+					"gender":      updEntry.Gender,
+					"nationality": updEntry.Nationality,
+				}
+				if setVerified {
+					fields["verified"] = updEntry.Verified
+				}
+				err = store.Update(p.Context, cond, arg, fields)
 				if err != nil {
-					log.Error(f+"FLUSHALL failed: ", err)
-				} else {
-					log.Debug(f+"FLUSHALL success: ", status)
+					return nil, err
 				}
-				return updEntry, nil
+				invalidateCache(f)
+				updEntry = models.Entry{}
+				err = store.First(p.Context, &updEntry, cond, arg)
+				return updEntry, err
 			},
 		},
 		"deleted_entry": &graphql.Field{
 			Type: entryType,
 			Args: graphql.FieldConfigArgument{
 				"id": &graphql.ArgumentConfig{
-					Type: graphql.NewNonNull(graphql.Int),
+					Type: graphql.Int,
+				},
+				"uuid": &graphql.ArgumentConfig{
+					Type: graphql.String,
 				},
 			},
 			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 				f := logging.F()
+				if readOnly() {
+					return nil, models.ErrReadOnly
+				}
 				id, _ := p.Args["id"].(int)
+				uid, _ := p.Args["uuid"].(string)
 				delEntry := models.Entry{
 					ID: uint(id),
 				}
+				if uid != "" {
+					delEntry.UUID = &uid
+				}
 				log.WithFields(logrus.Fields{
-					"ID": delEntry.ID,
+					"ID":   delEntry.ID,
+					"UUID": uid,
 				}).Debug(f + "delEntry")
-				err := db.C.First(&delEntry, "id = ?", delEntry.ID).Error
+				cond, arg, err := models.EntryCond(delEntry.PublicID())
 				if err != nil {
 					return nil, err
 				}
-				err = db.C.Unscoped().Delete(&delEntry).Error
+				delEntry = models.Entry{}
+				err = store.First(p.Context, &delEntry, cond, arg)
 				if err != nil {
-					log.Error(f+"failed to delete entry: ", err)
 					return nil, err
 				}
-				status, err := cRedis.FlushAll(ctx).Result()
+				err = store.Delete(p.Context, &delEntry)
 				if err != nil {
-					log.Error(f+"FLUSHALL failed: ", err)
-				} else {
-					log.Debug(f+"FLUSHALL success: ", status)
+					log.Error(f+"failed to delete entry: ", err)
+					return nil, err
 				}
+				invalidateCache(f)
 				return delEntry, nil
 			},
 		},
+		"update_where": &graphql.Field{
+			Type: graphql.Int,
+			Args: graphql.FieldConfigArgument{
+				"col": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"data": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"set": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(entryInput),
+				},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				f := logging.F()
+				if readOnly() {
+					return nil, models.ErrReadOnly
+				}
+				admin, _ := p.Context.Value(adminKey).(bool)
+				if !admin {
+					return nil, errors.New("administrator access required")
+				}
+				filterCol, _ := p.Args["col"].(string)
+				filterData, _ := p.Args["data"].(string)
+				set, _ := p.Args["set"].(map[string]interface{})
+				log.WithFields(logrus.Fields{
+					"Column": filterCol,
+					"Data":   filterData,
+					"Set":    set,
+				}).Debug(f + "update_where")
+				column, ok := models.FilterColumn(filterCol)
+				if !ok {
+					return nil, fmt.Errorf(
+						`column "%s" is not filterable`,
+						filterCol,
+					)
+				}
+				updates, err := models.ValidUpdates(set)
+				if err != nil {
+					return nil, err
+				}
+				query := db.C.Model(&models.Entry{}).
+					Where(column.Name+" = ?", filterData).
+					Updates(updates)
+				if query.Error != nil {
+					log.Error(f+"failed to update entries: ", query.Error)
+					return nil, query.Error
+				}
+				invalidateCache(f)
+				return query.RowsAffected, nil
+			},
+		},
 	},
 })
 
@@ -683,12 +2139,7 @@ var rootMutation = graphql.NewObject(graphql.ObjectConfig{
 	if err != nil {
 		return 500, "", err
 	}
-	status, err := cRedis.FlushAll(ctx).Result()
-	if err != nil {
-		log.Error(f+"FLUSHALL failed: ", err)
-	} else {
-		log.Debug(f+"FLUSHALL success: ", status)
-	}
+	invalidateCache(f)
 	return 200, "Success", nil
 } */
 
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"people/logging"
+	"people/models"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// The error of the single CSV line for the import report.
+type lineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// The default upload size limit of the imports in bytes.
+const importMaxBytes = 10485760
+
+// The function returns the upload size limit of the imports from the
+// IMPORT_MAX_BYTES environment variable, the default if empty,
+// otherwise returns an error.
+func uploadLimit() (int64, error) {
+	value := os.Getenv("IMPORT_MAX_BYTES")
+	if value == "" {
+		return importMaxBytes, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid IMPORT_MAX_BYTES %q", value)
+	}
+	return limit, nil
+}
+
+// This API handler imports the entries from the uploaded multipart CSV
+// file with the header row. Every line is validated, the missing age,
+// gender and nationality of the valid lines are enriched when the
+// "enrich" flag is set, and the valid entries are saved in batches of
+// DB_BATCH_SIZE and sent to the webhooks. The malformed CSV lines are
+// reported and skipped.
+// Return a JSON report with the number of saved entries and the errors
+// per line, or an error with its cause.
+func Import(c *gin.Context) {
+	f := logging.F()
+	maxBytes, err := uploadLimit()
+	if err != nil {
+		log.Error(f+"invalid upload size limit: ", err)
+		sendError(c, 500, models.CodeInternal, "Import failed", nil)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+	enrich, _ := strconv.ParseBool(c.DefaultPostForm("enrich", "false"))
+	file, err := c.FormFile("file")
+	if err != nil {
+		log.Debug(f+"upload failed: ", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			sendError(c, 413, models.CodeTooLarge, "File is too large", err)
+			return
+		}
+		sendError(c, 400, models.CodeBadRequest, "Invalid CSV upload", err)
+		return
+	}
+	upload, err := file.Open()
+	if err != nil {
+		log.Error(f+"failed to open upload: ", err)
+		sendError(c, 500, models.CodeInternal, "Import failed", nil)
+		return
+	}
+	defer upload.Close()
+	reader := csv.NewReader(upload)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		log.Debug(f+"invalid CSV header: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid CSV header", err)
+		return
+	}
+	columns := make(map[string]int)
+	for i, col := range header {
+		columns[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	var entries []models.Entry
+	report := []lineError{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				report = append(
+					report, lineError{parseErr.StartLine, err.Error()},
+				)
+				continue
+			}
+			report = append(report, lineError{0, err.Error()})
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		entry, err := importEntry(
+			c.Request.Context(), columns, record, enrich,
+		)
+		if err != nil {
+			report = append(report, lineError{line, err.Error()})
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	log.WithFields(logrus.Fields{
+		"Valid":   len(entries),
+		"Invalid": len(report),
+		"Enrich":  enrich,
+	}).Debug(f + "import")
+	if len(entries) > 0 {
+		err = store.CreateBatch(c.Request.Context(), entries)
+		if err != nil {
+			log.Error(f+"failed to import entries: ", err)
+			sendError(c, 500, models.CodeInternal, "Failed to import", nil)
+			return
+		}
+		invalidateCache(f)
+		for _, entry := range entries {
+			notifyCreated(entry)
+		}
+	}
+	c.JSON(200, gin.H{"imported": len(entries), "errors": report})
+}
+
+// The function creates the Entry model from the CSV record by the
+// header columns, checks the supplied fields before the enrichment
+// within the context, if necessary, and the validity of the result,
+// otherwise returns an error.
+func importEntry(
+	ctx context.Context,
+	columns map[string]int,
+	record []string,
+	enrich bool,
+) (models.Entry, error) {
+	value := func(col string) string {
+		i, ok := columns[col]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	entry := models.Entry{
+		Name:        value("name"),
+		Surname:     value("surname"),
+		Patronymic:  value("patronymic"),
+		Gender:      value("gender"),
+		Nationality: value("nationality"),
+	}
+	entry.Normalize()
+	if age := value("age"); age != "" {
+		number, err := strconv.ParseFloat(age, 64)
+		if err != nil {
+			return entry, models.ErrAgeNotInteger
+		}
+		entry.Age, err = models.ParseAge(number)
+		if err != nil {
+			return entry, err
+		}
+	}
+	if enrich {
+		fullName := models.FullName{
+			Name:        entry.Name,
+			Surname:     entry.Surname,
+			Patronymic:  entry.Patronymic,
+			Age:         entry.Age,
+			Gender:      entry.Gender,
+			Nationality: entry.Nationality,
+		}
+		if cause := fullName.IsValid(); cause != "" {
+			return entry, errors.New(cause)
+		}
+		err := entry.EnrichContext(ctx, entry.Name)
+		if err != nil {
+			return entry, fmt.Errorf("failed to enrich data from API: %v", err)
+		}
+	}
+	return entry, entry.IsValid()
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"os"
+	"people/models"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var inflight = &registry{items: make(map[uint64]inflightMsg)}
+
+// The message currently processed by ProcessMsg().
+type inflightMsg struct {
+	ID        uint64    `json:"id"`
+	Source    string    `json:"source"`
+	Name      string    `json:"name"`
+	Surname   string    `json:"surname"`
+	StartedAt time.Time `json:"started_at"`
+	Age       string    `json:"age"`
+}
+
+// The bounded registry of the in-flight messages. The messages above
+// the INFLIGHT_MAX limit are counted without tracking.
+type registry struct {
+	mu        sync.Mutex
+	next      uint64
+	items     map[uint64]inflightMsg
+	untracked int
+}
+
+// The function returns the maximum number of the tracked messages.
+func inflightMax() int {
+	max, err := strconv.Atoi(os.Getenv("INFLIGHT_MAX"))
+	if err != nil || max < 1 {
+		return 1000
+	}
+	return max
+}
+
+// The method registers the message and returns its ID. Zero ID means
+// the message is not tracked because the registry is full.
+func (r *registry) add(source string, name string, surname string) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.items) >= inflightMax() {
+		r.untracked++
+		return 0
+	}
+	r.next++
+	r.items[r.next] = inflightMsg{
+		ID:        r.next,
+		Source:    source,
+		Name:      name,
+		Surname:   surname,
+		StartedAt: time.Now(),
+	}
+	return r.next
+}
+
+// The method removes the processed message from the registry.
+func (r *registry) remove(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id == 0 {
+		r.untracked--
+		return
+	}
+	delete(r.items, id)
+}
+
+// The method returns the in-flight messages from the oldest one with
+// their processing age and the number of the untracked messages.
+func (r *registry) list() ([]inflightMsg, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msgs := make([]inflightMsg, 0, len(r.items))
+	for _, msg := range r.items {
+		msg.Age = time.Since(msg.StartedAt).Round(time.Millisecond).String()
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].ID < msgs[j].ID
+	})
+	return msgs, r.untracked
+}
+
+// This API handler lists the messages currently processed to spot the
+// stuck enrichment. Available in the debug mode or with the
+// administrator token. Return a JSON message with data or an error with
+// its cause.
+func Inflight(c *gin.Context) {
+	if gin.Mode() != gin.DebugMode && !isAdmin(c) {
+		sendError(c, 401, models.CodeUnauthorized, "Unauthorized", nil)
+		return
+	}
+	msgs, untracked := inflight.list()
+	c.JSON(200, gin.H{"inflight": msgs, "untracked": untracked})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"os"
+	"people/models"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The API key scope of the access to the unmasked personal data.
+const piiScope = "pii"
+
+// The context key of the unmasked access flag of the GraphQL request.
+const unmaskedKey ctxKey = "unmasked"
+
+// The function returns the scopes of the API key from the X-API-Key
+// header by the API_KEYS environment variable in the format
+// "key:scope scope,key:scope".
+func scopes(c *gin.Context) []string {
+	header := []byte(c.GetHeader("X-API-Key"))
+	if len(header) == 0 {
+		return nil
+	}
+	for _, item := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		key, list, _ := strings.Cut(strings.TrimSpace(item), ":")
+		if key != "" && subtle.ConstantTimeCompare(header, []byte(key)) == 1 {
+			return strings.Fields(list)
+		}
+	}
+	return nil
+}
+
+// The function reports whether the request has the access to the
+// unmasked personal data with the administrator token or the API key
+// with the privileged scope.
+func unmasked(c *gin.Context) bool {
+	if isAdmin(c) {
+		return true
+	}
+	for _, scope := range scopes(c) {
+		if scope == piiScope {
+			return true
+		}
+	}
+	return false
+}
+
+// The function masks the fields from the MASK_FIELDS environment
+// variable in the fetched entries before their serialization.
+func maskEntries(entries []models.Entry) {
+	for _, field := range strings.Split(os.Getenv("MASK_FIELDS"), ",") {
+		for i := range entries {
+			switch strings.ToLower(strings.TrimSpace(field)) {
+			case "name":
+				entries[i].Name = mask(entries[i].Name)
+			case "surname":
+				entries[i].Surname = mask(entries[i].Surname)
+			case "patronymic":
+				entries[i].Patronymic = mask(entries[i].Patronymic)
+			}
+		}
+	}
+}
+
+// The function masks the fields from the MASK_FIELDS environment
+// variable in the processing event before its streaming.
+func maskEvent(event models.Event) models.Event {
+	for _, field := range strings.Split(os.Getenv("MASK_FIELDS"), ",") {
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "name":
+			event.Name = mask(event.Name)
+		case "surname":
+			event.Surname = mask(event.Surname)
+		}
+	}
+	return event
+}
+
+// The function reports whether the GraphQL request context has the
+// unmasked access.
+func unmaskedCtx(ctx context.Context) bool {
+	value, _ := ctx.Value(unmaskedKey).(bool)
+	return value
+}
+
+// The function masks the entries of the REST response for the request
+// without the unmasked access.
+func maskFor(c *gin.Context, entries []models.Entry) {
+	if os.Getenv("MASK_FIELDS") == "" {
+		return
+	}
+	c.Writer.Header().Add("Vary", "X-API-Key")
+	if !unmasked(c) {
+		maskEntries(entries)
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	db "people/database"
+	"people/logging"
+	"people/models"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// The sources of the merged field values.
+const (
+	mergeKeep   = "keep"
+	mergeRemove = "remove"
+)
+
+// The fields of the Entry model merged by the Merge() handler.
+var mergeFields = []string{
+	"name",
+	"surname",
+	"patronymic",
+	"age",
+	"gender",
+	"nationality",
+}
+
+// The public entry ID decoded from the JSON number or string.
+type publicID string
+
+// The method decodes the public ID from the JSON number or string,
+// otherwise returns an error.
+func (id *publicID) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*id = publicID(value)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	*id = publicID(number)
+	return nil
+}
+
+// The request of the Merge() handler. The field overrides choose the
+// "keep" or "remove" entry as the source of the field value.
+type mergeRequest struct {
+	KeepID         publicID          `json:"keep_id" binding:"required"`
+	RemoveID       publicID          `json:"remove_id" binding:"required"`
+	FieldOverrides map[string]string `json:"field_overrides"`
+}
+
+// The function returns the merged field values of the Entry model.
+func mergeValues(e models.Entry) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        e.Name,
+		"surname":     e.Surname,
+		"patronymic":  e.Patronymic,
+		"age":         e.Age,
+		"gender":      e.Gender,
+		"nationality": e.Nationality,
+	}
+}
+
+// This API handler merges the removed entry into the kept one in a
+// transaction. Each field keeps its value unless it is empty and the
+// removed entry has one, or the "field_overrides" choose the source.
+// The provenance of the taken fields is moved to the kept entry, the
+// removed entry is soft-deleted, the merge is written to the audit table
+// and the Redis cache is dumped. Return a JSON message with the kept
+// entry and the field sources, or an error with its cause.
+func Merge(c *gin.Context) {
+	f := logging.F()
+	var req mergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Debug(f+"parsing failed: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid API query", err)
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"KeepID":         req.KeepID,
+		"RemoveID":       req.RemoveID,
+		"FieldOverrides": req.FieldOverrides,
+	}).Debug(f + "merge")
+	if req.KeepID == req.RemoveID {
+		message := "Entry cannot be merged into itself"
+		sendError(c, 400, models.CodeBadRequest, message, nil)
+		return
+	}
+	for field, source := range req.FieldOverrides {
+		if _, ok := mergeValues(models.Entry{})[field]; !ok {
+			message := fmt.Sprintf(`Unknown override field "%s"`, field)
+			sendError(c, 400, models.CodeBadRequest, message, nil)
+			return
+		}
+		if source != mergeKeep && source != mergeRemove {
+			message := fmt.Sprintf(`Invalid override source "%s"`, source)
+			sendError(c, 400, models.CodeBadRequest, message, nil)
+			return
+		}
+	}
+	keepCond, keepArg, err := models.EntryCond(string(req.KeepID))
+	if err != nil {
+		log.Debug(f+"invalid entry ID: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid ID parameter", err)
+		return
+	}
+	removeCond, removeArg, err := models.EntryCond(string(req.RemoveID))
+	if err != nil {
+		log.Debug(f+"invalid entry ID: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid ID parameter", err)
+		return
+	}
+	var keep, remove models.Entry
+	var missing publicID
+	sources := make(map[string]string, len(mergeFields))
+	ctx := c.Request.Context()
+	err = db.C.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&keep, keepCond, keepArg).Error; err != nil {
+			missing = req.KeepID
+			return err
+		}
+		if err := tx.First(&remove, removeCond, removeArg).Error; err != nil {
+			missing = req.RemoveID
+			return err
+		}
+		kept, removed := mergeValues(keep), mergeValues(remove)
+		updates := make(map[string]interface{})
+		var moved []string
+		for _, field := range mergeFields {
+			source := req.FieldOverrides[field]
+			if source == "" {
+				source = mergeKeep
+				if reflect.ValueOf(kept[field]).IsZero() &&
+					!reflect.ValueOf(removed[field]).IsZero() {
+					source = mergeRemove
+				}
+			}
+			sources[field] = source
+			if source == mergeRemove {
+				updates[field] = removed[field]
+				moved = append(moved, field)
+			}
+		}
+		if len(updates) > 0 {
+			err := tx.Model(&keep).Updates(updates).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Where("entry_id = ? AND field IN ?", keep.ID, moved).
+				Delete(&models.Provenance{}).
+				Error
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&models.Provenance{}).
+				Where("entry_id = ? AND field IN ?", remove.ID, moved).
+				Update("entry_id", keep.ID).
+				Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&remove).Error; err != nil {
+			return err
+		}
+		fields, err := json.Marshal(sources)
+		if err != nil {
+			return err
+		}
+		err = tx.Create(&models.Merge{
+			KeptID:    keep.ID,
+			RemovedID: remove.ID,
+			Fields:    string(fields),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.First(&keep, keep.ID).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) && missing != "" {
+		sendError(
+			c,
+			404,
+			models.CodeNotFound,
+			fmt.Sprintf(`Entry "%v" does not exist`, missing),
+			nil,
+		)
+		return
+	}
+	if err != nil {
+		log.Error(f+"merge failed: ", err)
+		sendError(c, 500, models.CodeInternal, "Request failed", nil)
+		return
+	}
+	invalidateCache(f)
+	entries := []models.Entry{keep}
+	maskFor(c, entries)
+	respond(c, 200, gin.H{"entry": entries[0], "fields": sources})
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"people/logging"
+	"people/models"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var cacheStats = newCacheCounters()
+
+// The counters of the entries cache lookups in Read() and GraphQL.
+type cacheCounters struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+	since  atomic.Int64
+}
+
+// The function creates the cache counters started now.
+func newCacheCounters() *cacheCounters {
+	counters := &cacheCounters{}
+	counters.since.Store(time.Now().UnixNano())
+	return counters
+}
+
+// The method counts the cache lookup result.
+func (c *cacheCounters) record(hit bool) {
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+// The method returns the current counters and the hit ratio, resetting
+// the counters if requested.
+func (c *cacheCounters) snapshot(reset bool) gin.H {
+	var hits, misses, since int64
+	if reset {
+		since = c.since.Swap(time.Now().UnixNano())
+		hits = c.hits.Swap(0)
+		misses = c.misses.Swap(0)
+	} else {
+		since = c.since.Load()
+		hits = c.hits.Load()
+		misses = c.misses.Load()
+	}
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+	return gin.H{
+		"hits":   hits,
+		"misses": misses,
+		"ratio":  ratio,
+		"since":  time.Unix(0, since).UTC().Format(time.RFC3339),
+	}
+}
+
+// This API handler returns the entries cache hit and miss counts with
+// their ratio since the start or the last reset. The "reset" flag
+// requires the administrator token. Return a JSON message with data or
+// an error with its cause.
+func CacheMetrics(c *gin.Context) {
+	reset, err := strconv.ParseBool(c.DefaultQuery("reset", "false"))
+	if err != nil {
+		sendError(c, 400, models.CodeBadRequest, "Invalid reset parameter", err)
+		return
+	}
+	if reset && !isAdmin(c) {
+		sendError(c, 401, models.CodeUnauthorized, "Unauthorized", nil)
+		return
+	}
+	c.JSON(200, cacheStats.snapshot(reset))
+}
+
+// The normalized reasons of the message processing failures.
+const (
+	failValidation      = "validation"
+	failProviderTimeout = "provider_timeout"
+	failProviderError   = "provider_error"
+	failDB              = "db_error"
+	failRejected        = "rejected"
+)
+
+var enrichFailures = newFailureCounters()
+
+// The counters of the message processing failures in ProcessMsg() by
+// their normalized reasons, so the alerts have a fixed set of labels.
+type failureCounters map[string]*atomic.Int64
+
+// The function creates the zero failure counters of all reasons.
+func newFailureCounters() failureCounters {
+	counters := make(failureCounters)
+	for _, reason := range []string{
+		failValidation,
+		failProviderTimeout,
+		failProviderError,
+		failDB,
+		failRejected,
+	} {
+		counters[reason] = &atomic.Int64{}
+	}
+	return counters
+}
+
+// The method counts the failure by its reason.
+func (c failureCounters) add(reason string) {
+	c[reason].Add(1)
+}
+
+// The method returns the current counters by the reasons.
+func (c failureCounters) snapshot() map[string]int64 {
+	counts := make(map[string]int64, len(c))
+	for reason, counter := range c {
+		counts[reason] = counter.Load()
+	}
+	return counts
+}
+
+// The function returns the failure reason of the enrichment error. The
+// implausible values are the validation failures.
+func enrichReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, models.ErrImplausible):
+		return failValidation
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return failProviderTimeout
+	default:
+		return failProviderError
+	}
+}
+
+// This API handler returns the counts of the message processing failures
+// by the reasons "validation", "provider_timeout", "provider_error",
+// "db_error" and "rejected" for the read-only mode and the duplicates.
+// The "format" parameter "prometheus" returns them as the counter in the
+// Prometheus text format instead of JSON.
+func EnrichFailures(c *gin.Context) {
+	counts := enrichFailures.snapshot()
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(200, gin.H{"failures": counts})
+	case "prometheus":
+		reasons := make([]string, 0, len(counts))
+		for reason := range counts {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		var b strings.Builder
+		b.WriteString("# HELP people_enrich_failures_total " +
+			"Failures of the message processing by the reason.\n")
+		b.WriteString("# TYPE people_enrich_failures_total counter\n")
+		for _, reason := range reasons {
+			fmt.Fprintf(
+				&b,
+				"people_enrich_failures_total{reason=%q} %d\n",
+				reason,
+				counts[reason],
+			)
+		}
+		c.Data(200, "text/plain; version=0.0.4", []byte(b.String()))
+	default:
+		sendError(
+			c, 400, models.CodeBadRequest, "Invalid format parameter", nil,
+		)
+	}
+}
+
+// The entries cache key with its remaining TTL and the age derived from
+// CACHE_TTL.
+type cachedKey struct {
+	Key   string `json:"key"`
+	TTL   string `json:"ttl"`
+	Age   string `json:"age"`
+	Stale bool   `json:"stale"`
+}
+
+// This API handler lists a page of the entries cache keys of the
+// current namespace by the SCAN "cursor" with up to "count" keys, their
+// TTL and age. The keys of the old generations are marked stale.
+// Available in the debug mode or with the administrator token. Return a
+// JSON message with the keys and the next cursor, 0 at the end, or an
+// error with its cause.
+func CacheKeys(c *gin.Context) {
+	f := logging.F()
+	if gin.Mode() != gin.DebugMode && !isAdmin(c) {
+		sendError(c, 401, models.CodeUnauthorized, "Unauthorized", nil)
+		return
+	}
+	cursor, err := strconv.ParseUint(c.DefaultQuery("cursor", "0"), 10, 64)
+	if err != nil {
+		sendError(
+			c, 400, models.CodeBadRequest, "Invalid cursor parameter", err,
+		)
+		return
+	}
+	count, err := strconv.ParseInt(c.DefaultQuery("count", "100"), 10, 64)
+	if err != nil || count < 1 || count > 1000 {
+		sendError(c, 400, models.CodeBadRequest, "Invalid count parameter", err)
+		return
+	}
+	reqCtx := c.Request.Context()
+	pattern := nsKey("entries:*")
+	names, next, err := cRedis.Scan(reqCtx, cursor, pattern, count).Result()
+	if err != nil {
+		log.Error(f+"cache scan failed: ", err)
+		sendError(c, 500, models.CodeInternal, "Request failed", nil)
+		return
+	}
+	current := nsKey(fmt.Sprintf("entries:%d:", generation(reqCtx)))
+	keys := make([]cachedKey, 0, len(names))
+	for _, name := range names {
+		if name == nsKey(generationKey) {
+			continue
+		}
+		ttl, err := cRedis.TTL(reqCtx, name).Result()
+		if err != nil || ttl < 0 {
+			// The key expired after the scan or it has no TTL.
+			continue
+		}
+		keys = append(keys, cachedKey{
+			Key:   name,
+			TTL:   ttl.Round(time.Second).String(),
+			Age:   (cacheTTL - ttl).Round(time.Second).String(),
+			Stale: !strings.HasPrefix(name, current),
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	c.JSON(200, gin.H{"keys": keys, "cursor": next})
+}
+
+// This API handler returns the last known rate limit quotas of the
+// enrichment providers. The "format" parameter "prometheus" returns them
+// as the gauges in the Prometheus text format instead of JSON.
+func EnrichQuota(c *gin.Context) {
+	quotas := models.Quotas()
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(200, gin.H{"quotas": quotas})
+	case "prometheus":
+		var b strings.Builder
+		b.WriteString("# HELP people_provider_quota_remaining " +
+			"Remaining rate limit quota of the enrichment provider.\n")
+		b.WriteString("# TYPE people_provider_quota_remaining gauge\n")
+		for _, quota := range quotas {
+			fmt.Fprintf(
+				&b,
+				"people_provider_quota_remaining{provider=%q,url=%q} %d\n",
+				quota.Provider,
+				quota.URL,
+				quota.Remaining,
+			)
+		}
+		c.Data(200, "text/plain; version=0.0.4", []byte(b.String()))
+	default:
+		sendError(
+			c, 400, models.CodeBadRequest, "Invalid format parameter", nil,
+		)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"os"
+	db "people/database"
+	"people/logging"
+	"people/models"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The lock of the runtime migrations, so the concurrent requests do not
+// apply the same migration twice.
+var migrating sync.Mutex
+
+// The function reports whether the schema endpoints are enabled by the
+// MIGRATE_API variable. Empty value disables them in the release mode
+// only.
+func migrateAPI() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("MIGRATE_API"))
+	if err != nil {
+		return gin.Mode() != gin.ReleaseMode
+	}
+	return enabled
+}
+
+// The function checks the access to the schema endpoints and responds
+// with an error if denied.
+func schemaAccess(c *gin.Context) bool {
+	if !migrateAPI() {
+		sendError(c, 403, models.CodeForbidden, "Schema API disabled", nil)
+		return false
+	}
+	if !isAdmin(c) {
+		sendError(c, 401, models.CodeUnauthorized, "Unauthorized", nil)
+		return false
+	}
+	return true
+}
+
+// This API handler applies the pending versioned migrations at runtime
+// without the restart. Requires the administrator token and the
+// confirmation flag. Return a JSON message with the applied migrations
+// and the current version or an error with its cause.
+func Migrate(c *gin.Context) {
+	f := logging.F()
+	if !schemaAccess(c) {
+		return
+	}
+	var req struct {
+		Confirm bool `json:"confirm"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !req.Confirm {
+		log.Debug(f+"parsing failed: ", err)
+		sendError(
+			c, 400, models.CodeBadRequest, `Confirm with "confirm": true`, err,
+		)
+		return
+	}
+	migrating.Lock()
+	defer migrating.Unlock()
+	conn := db.C.WithContext(c.Request.Context())
+	applied, err := db.Apply(conn, db.Migrations)
+	if err != nil {
+		log.Error(f+"runtime migration failed: ", err)
+		sendError(c, 500, models.CodeInternal, "Migration failed", err)
+		return
+	}
+	version, _, err := db.Status(conn, db.Migrations)
+	if err != nil {
+		log.Error(f+"failed to read schema version: ", err)
+		sendError(c, 500, models.CodeInternal, "Request failed", nil)
+		return
+	}
+	log.Infof(f+"runtime migrations applied: %d", len(applied))
+	c.JSON(200, gin.H{"applied": applied, "version": version})
+}
+
+// This API handler reports the current schema version, the pending
+// migrations and the drift of the models from the database. Requires the
+// administrator token. Return a JSON message with the schema state or
+// an error with its cause.
+func SchemaStatus(c *gin.Context) {
+	f := logging.F()
+	if !schemaAccess(c) {
+		return
+	}
+	conn := db.C.WithContext(c.Request.Context())
+	version, pending, err := db.Status(conn, db.Migrations)
+	if err != nil {
+		log.Error(f+"failed to read schema version: ", err)
+		sendError(c, 500, models.CodeInternal, "Request failed", nil)
+		return
+	}
+	drift, err := db.Drift(conn)
+	if err != nil {
+		log.Error(f+"failed to compare schema: ", err)
+		sendError(c, 500, models.CodeInternal, "Request failed", nil)
+		return
+	}
+	c.JSON(200, gin.H{
+		"version": version,
+		"pending": pending,
+		"drift":   drift,
+	})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"people/logging"
+	"people/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// The Redis key prefix of the persisted GraphQL queries.
+const persistedPrefix = "graphql:persisted:"
+
+// The Redis key of the automatically registered queries by their expiry.
+const persistedIndex = "graphql:persisted_index"
+
+// The limits of the automatically registered persisted queries.
+var (
+	persistedTTL time.Duration
+	persistedMax int
+)
+
+// The function parses the GRAPHQL_PERSISTED_TTL and GRAPHQL_PERSISTED_MAX
+// of the automatically registered queries, the invalid ones are fatal.
+// The zero TTL keeps the registered queries without the expiry.
+func initPersisted() {
+	persistedTTL = 24 * time.Hour
+	if value := os.Getenv("GRAPHQL_PERSISTED_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			log.Fatalf("Failed to parse persisted query TTL %q", value)
+		}
+		persistedTTL = ttl
+	}
+	persistedMax = 1000
+	if value := os.Getenv("GRAPHQL_PERSISTED_MAX"); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max < 1 {
+			log.Fatalf("Failed to parse persisted query limit %q", value)
+		}
+		persistedMax = max
+	}
+}
+
+// The function registers the persisted query for the GRAPHQL_PERSISTED_TTL
+// unless GRAPHQL_PERSISTED_MAX queries are already registered. The
+// expired queries are removed from the count first.
+func registerPersisted(hash, query string) error {
+	f := logging.F()
+	index := nsKey(persistedIndex)
+	key := nsKey(persistedPrefix + hash)
+	now := time.Now()
+	if persistedTTL > 0 {
+		expired := strconv.FormatInt(now.Unix(), 10)
+		err := cRedis.ZRemRangeByScore(ctx, index, "-inf", expired).Err()
+		if err != nil {
+			return err
+		}
+	}
+	count, err := cRedis.ZCard(ctx, index).Result()
+	if err != nil {
+		return err
+	}
+	if count >= int64(persistedMax) {
+		exists, err := cRedis.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			log.Warn(f + "persisted query limit reached, query not registered")
+			return nil
+		}
+	}
+	expires := float64(0)
+	if persistedTTL > 0 {
+		expires = float64(now.Add(persistedTTL).Unix())
+	}
+	_, err = cRedis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, query, persistedTTL)
+		pipe.ZAdd(ctx, index, redis.Z{Score: expires, Member: hash})
+		return nil
+	})
+	return err
+}
+
+// The model of the GraphQL request with the variables and the persisted
+// query extension.
+type graphqlRequest struct {
+	Query      string                 `json:"query"`
+	Variables  map[string]interface{} `json:"variables"`
+	Extensions struct {
+		PersistedQuery *struct {
+			Version int    `json:"version"`
+			Hash    string `json:"sha256Hash"`
+		} `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// The function reports whether only the registered persisted queries
+// are executed. In the allowlist mode the queries are registered by the
+// administrator only.
+func allowlist() bool {
+	return os.Getenv("GRAPHQL_ALLOWLIST") == "true"
+}
+
+// The function resolves the query text of the GraphQL request by the
+// automatic persisted queries protocol. A known hash is replaced with
+// its query, a full query with the hash is registered, permanently in
+// the allowlist mode and within the limits otherwise. Responds and
+// returns false if the query cannot be executed.
+func persistedQuery(c *gin.Context, req graphqlRequest) (string, bool) {
+	f := logging.F()
+	persisted := req.Extensions.PersistedQuery
+	if persisted == nil {
+		if allowlist() && !isAdmin(c) {
+			sendError(
+				c, 403, models.CodeForbidden, "Persisted query required", nil,
+			)
+			return "", false
+		}
+		return req.Query, true
+	}
+	if persisted.Version != 1 || persisted.Hash == "" {
+		sendError(
+			c, 400, models.CodeBadRequest, "Unsupported persisted query", nil,
+		)
+		return "", false
+	}
+	key := nsKey(persistedPrefix + persisted.Hash)
+	if req.Query == "" {
+		query, err := cRedis.Get(ctx, key).Result()
+		if err == redis.Nil {
+			c.JSON(200, gin.H{"errors": []gin.H{{
+				"message":    "PersistedQueryNotFound",
+				"extensions": gin.H{"code": "PERSISTED_QUERY_NOT_FOUND"},
+			}}})
+			return "", false
+		}
+		if err != nil {
+			log.Error(f+"failed to read persisted query: ", err)
+			sendError(c, 500, models.CodeInternal, "Request failed", nil)
+			return "", false
+		}
+		return query, true
+	}
+	sum := sha256.Sum256([]byte(req.Query))
+	if hex.EncodeToString(sum[:]) != persisted.Hash {
+		sendError(
+			c, 400, models.CodeBadRequest, "Persisted query hash mismatch", nil,
+		)
+		return "", false
+	}
+	if allowlist() && !isAdmin(c) {
+		exists, err := cRedis.Exists(ctx, key).Result()
+		if err != nil || exists == 0 {
+			sendError(
+				c, 403, models.CodeForbidden, "Query is not allowlisted", nil,
+			)
+			return "", false
+		}
+		return req.Query, true
+	}
+	var err error
+	if allowlist() {
+		err = cRedis.Set(ctx, key, req.Query, 0).Err()
+	} else {
+		err = registerPersisted(persisted.Hash, req.Query)
+	}
+	if err != nil {
+		log.Error(f+"failed to register persisted query: ", err)
+	}
+	return req.Query, true
+}
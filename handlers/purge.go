@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"io"
+	"os"
+	db "people/database"
+	"people/logging"
+	"people/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The settings of the soft deleted entries purge.
+type purgeConfig struct {
+	interval  time.Duration
+	retention time.Duration
+}
+
+// The function reads the purge settings from the PURGE_INTERVAL and
+// DELETE_RETENTION environment variables. The empty or zero interval
+// disables the worker.
+func purgeSettings() purgeConfig {
+	config := purgeConfig{retention: 30 * 24 * time.Hour}
+	var err error
+	if value := os.Getenv("PURGE_INTERVAL"); value != "" {
+		config.interval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Failed to parse purge interval: %v", err)
+		}
+	}
+	if value := os.Getenv("DELETE_RETENTION"); value != "" {
+		config.retention, err = time.ParseDuration(value)
+		if err != nil || config.retention < 0 {
+			log.Fatalf("Failed to parse deletion retention: %v", value)
+		}
+	}
+	return config
+}
+
+// The background scheduler of the soft deleted entries purge. Every
+// interval it permanently deletes the entries deleted earlier than the
+// retention until the stop channel is closed.
+func Purge(stop <-chan struct{}) {
+	f := logging.F()
+	config := purgeSettings()
+	if config.interval <= 0 {
+		log.Info("Purge worker disabled")
+		return
+	}
+	log.Infof("Purge worker started every %v", config.interval)
+	ticker := time.NewTicker(config.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if readOnly() {
+				log.Debug(f + "purge skipped in read-only mode")
+				continue
+			}
+			purged, err := purgeDeleted(config.retention)
+			if err != nil {
+				log.Error(f+"purge failed: ", err)
+				continue
+			}
+			log.Infof(f+"purged entries: %d", purged)
+		}
+	}
+}
+
+// The function permanently deletes the entries soft deleted earlier than
+// the retention and returns their number. Their provenance is removed by
+// the cascade of the foreign key.
+func purgeDeleted(retention time.Duration) (int64, error) {
+	result := db.C.Unscoped().
+		Where("deleted_at < ?", time.Now().Add(-retention)).
+		Delete(&models.Entry{})
+	return result.RowsAffected, result.Error
+}
+
+// This API handler permanently deletes the soft deleted entries older
+// than the optional "older_than" duration, the DELETE_RETENTION by
+// default. Requires the administrator token. Return a JSON message with
+// the number of the purged entries or an error with its cause.
+func PurgeDeleted(c *gin.Context) {
+	f := logging.F()
+	if !isAdmin(c) {
+		sendError(c, 401, models.CodeUnauthorized, "Unauthorized", nil)
+		return
+	}
+	var req struct {
+		OlderThan string `json:"older_than"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil && err != io.EOF {
+		log.Debug(f+"parsing failed: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid API query", err)
+		return
+	}
+	retention := purgeSettings().retention
+	if req.OlderThan != "" {
+		retention, err = time.ParseDuration(req.OlderThan)
+		if err != nil || retention < 0 {
+			log.Debug(f+"invalid retention: ", err)
+			sendError(
+				c, 400, models.CodeBadRequest, "Invalid older_than", err,
+			)
+			return
+		}
+	}
+	purged, err := purgeDeleted(retention)
+	if err != nil {
+		log.Error(f+"purge failed: ", err)
+		sendError(c, 500, models.CodeInternal, "Failed to purge entries", nil)
+		return
+	}
+	log.Infof(f+"purged entries: %d", purged)
+	c.JSON(200, gin.H{"purged": purged})
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"people/logging"
+	"people/models"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// The Redis key of the read-only mode toggled at runtime.
+const readOnlyKey = "read_only"
+
+// The local copy of the runtime toggle from Redis, the empty value if it
+// is not set. It is read again from Redis after the READ_ONLY_TTL.
+var readOnlyCache struct {
+	mu      sync.Mutex
+	value   string
+	expires time.Time
+	ttl     time.Duration
+}
+
+// The function parses the READ_ONLY_TTL of the local copy of the runtime
+// toggle and drops the copy. The zero TTL reads Redis on every check, the
+// invalid one is fatal.
+func initReadOnly() {
+	ttl := time.Second
+	if value := os.Getenv("READ_ONLY_TTL"); value != "" {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			log.Fatalf("Failed to parse read-only TTL %q", value)
+		}
+	}
+	readOnlyCache.mu.Lock()
+	defer readOnlyCache.mu.Unlock()
+	readOnlyCache.value = ""
+	readOnlyCache.expires = time.Time{}
+	readOnlyCache.ttl = ttl
+}
+
+// The function returns the read-only mode state. The runtime toggle
+// from Redis takes precedence over the READ_ONLY environment variable.
+// The toggle is kept locally for the READ_ONLY_TTL, so the other
+// instances follow it within the TTL.
+func readOnly() bool {
+	readOnlyCache.mu.Lock()
+	defer readOnlyCache.mu.Unlock()
+	now := time.Now()
+	if !now.Before(readOnlyCache.expires) {
+		value, err := cRedis.Get(ctx, nsKey(readOnlyKey)).Result()
+		if err != nil {
+			value = ""
+		}
+		readOnlyCache.value = value
+		readOnlyCache.expires = now.Add(readOnlyCache.ttl)
+	}
+	if readOnlyCache.value != "" {
+		return readOnlyCache.value == "1"
+	}
+	return os.Getenv("READ_ONLY") == "true"
+}
+
+// The middleware rejects the write requests in the read-only mode.
+func Writable(c *gin.Context) {
+	if readOnly() {
+		sendError(c, 503, models.CodeReadOnly, "Read-only mode", nil)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// The function checks whether the GraphQL error is caused by the
+// read-only mode.
+func isReadOnlyErr(formatted gqlerrors.FormattedError) bool {
+	err := formatted.OriginalError()
+	if gqlErr, ok := err.(*gqlerrors.Error); ok {
+		err = gqlErr.OriginalError
+	}
+	return errors.Is(err, models.ErrReadOnly)
+}
+
+// This API handler toggles the read-only mode at runtime for all
+// instances sharing Redis, the other ones follow it within the
+// READ_ONLY_TTL. Requires the administrator token. Return a JSON message
+// with the current state or an error with its cause.
+func SetReadOnly(c *gin.Context) {
+	f := logging.F()
+	if !isAdmin(c) {
+		sendError(c, 401, models.CodeUnauthorized, "Unauthorized", nil)
+		return
+	}
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		log.Debug(f+"parsing failed: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid API query", err)
+		return
+	}
+	value := "0"
+	if *req.Enabled {
+		value = "1"
+	}
+	err := cRedis.Set(ctx, nsKey(readOnlyKey), value, 0).Err()
+	if err != nil {
+		log.Error(f+"failed to toggle read-only mode: ", err)
+		sendError(c, 500, models.CodeInternal, "Failed to toggle mode", nil)
+		return
+	}
+	readOnlyCache.mu.Lock()
+	readOnlyCache.value = value
+	readOnlyCache.expires = time.Now().Add(readOnlyCache.ttl)
+	readOnlyCache.mu.Unlock()
+	log.Infof(f+"read-only mode: %v", *req.Enabled)
+	c.JSON(200, gin.H{"read_only": *req.Enabled})
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"os"
+	db "people/database"
+	"people/logging"
+	"people/models"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The Redis key of the last re-enriched entry ID to resume the scan.
+const reenrichCursorKey = "reenrich:cursor"
+
+// The providers of the fields kept by the re-enrichment: the values
+// supplied by the source system and the genders inferred from the
+// patronymics never become stale.
+var keptProviders = []string{"supplied", "patronymic"}
+
+// The settings of the stale records re-enrichment.
+type reenrichConfig struct {
+	interval time.Duration
+	stale    time.Duration
+	batch    int
+	workers  int
+}
+
+// The function reads the re-enrichment settings from the environment
+// variables. The empty or zero interval disables the worker.
+func reenrichSettings() reenrichConfig {
+	config := reenrichConfig{stale: 30 * 24 * time.Hour, batch: 50, workers: 3}
+	var err error
+	if value := os.Getenv("REENRICH_INTERVAL"); value != "" {
+		config.interval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Failed to parse re-enrichment interval: %v", err)
+		}
+	}
+	if value := os.Getenv("REENRICH_STALE"); value != "" {
+		config.stale, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Failed to parse re-enrichment staleness: %v", err)
+		}
+	}
+	if value := os.Getenv("REENRICH_BATCH"); value != "" {
+		config.batch, err = strconv.Atoi(value)
+		if err != nil || config.batch < 1 {
+			log.Fatalf("Failed to parse re-enrichment batch: %v", value)
+		}
+	}
+	if value := os.Getenv("REENRICH_WORKERS"); value != "" {
+		config.workers, err = strconv.Atoi(value)
+		if err != nil || config.workers < 1 {
+			log.Fatalf("Failed to parse re-enrichment workers: %v", value)
+		}
+	}
+	return config
+}
+
+// The background scheduler of the stale records re-enrichment. Every
+// interval it processes the next batch of the entries enriched earlier
+// than the staleness threshold until the stop channel is closed.
+func Reenrich(stop <-chan struct{}) {
+	config := reenrichSettings()
+	if config.interval <= 0 {
+		log.Info("Re-enrichment worker disabled")
+		return
+	}
+	log.Infof("Re-enrichment worker started every %v", config.interval)
+	ticker := time.NewTicker(config.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			reenrichBatch(config)
+		}
+	}
+}
+
+// The function re-enriches the batch of the stale entries after the
+// saved cursor with the bounded number of workers. The verified entries
+// are skipped. The cursor is reset when the scan reaches the end of the
+// table.
+func reenrichBatch(config reenrichConfig) {
+	f := logging.F()
+	if readOnly() {
+		log.Debug(f + "re-enrichment skipped in read-only mode")
+		return
+	}
+	cursor, _ := cRedis.Get(ctx, nsKey(reenrichCursorKey)).Uint64()
+	stale := db.C.Model(&models.Provenance{}).
+		Select("entry_id").
+		Where(
+			"provider NOT IN ? AND fetched_at < ?",
+			keptProviders,
+			time.Now().Add(-config.stale),
+		)
+	var entries []models.Entry
+	err := db.C.Preload("Provenance").
+		Where("id > ? AND id IN (?) AND NOT verified", cursor, stale).
+		Order("id").
+		Limit(config.batch).
+		Find(&entries).
+		Error
+	if err != nil {
+		log.Error(f+"failed to find stale entries: ", err)
+		return
+	}
+	if len(entries) == 0 {
+		cRedis.Del(ctx, nsKey(reenrichCursorKey))
+		return
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, config.workers)
+	for i := range entries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(entry *models.Entry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			reenrichEntry(entry)
+		}(&entries[i])
+	}
+	wg.Wait()
+	last := entries[len(entries)-1].ID
+	cRedis.Set(ctx, nsKey(reenrichCursorKey), last, 0)
+	invalidateCache(f)
+}
+
+// The function requests the providers again for the enriched fields of
+// the entry and replaces its values and provenance in place. The fields
+// of the kept providers are kept with their provenance, the entry
+// verified in the meantime is not changed.
+func reenrichEntry(entry *models.Entry) {
+	f := logging.F()
+	fresh := models.Entry{Name: entry.Name, Patronymic: entry.Patronymic}
+	kept := make(map[string]models.Provenance)
+	for _, prov := range entry.Provenance {
+		if !slices.Contains(keptProviders, prov.Provider) {
+			continue
+		}
+		kept[prov.Field] = prov
+		switch prov.Field {
+		case "age":
+			fresh.Age = entry.Age
+		case "gender":
+			fresh.Gender = entry.Gender
+		case "nationality":
+			fresh.Nationality = entry.Nationality
+		}
+	}
+	err := fresh.Enrich(entry.Name)
+	if err != nil {
+		log.Errorf(f+"failed to re-enrich entry %d: %v", entry.ID, err)
+		return
+	}
+	for i, prov := range fresh.Provenance {
+		if old, ok := kept[prov.Field]; ok && old.Provider != "supplied" {
+			old.ID = 0
+			fresh.Provenance[i] = old
+		}
+	}
+	verified := false
+	err = db.C.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(entry).
+			Where("verified = ?", false).
+			Updates(map[string]interface{}{
+				"age":         fresh.Age,
+				"gender":      fresh.Gender,
+				"nationality": fresh.Nationality,
+			})
+		if query.Error != nil {
+			return query.Error
+		}
+		if query.RowsAffected == 0 {
+			verified = true
+			return nil
+		}
+		err = tx.Where("entry_id = ?", entry.ID).
+			Delete(&models.Provenance{}).
+			Error
+		if err != nil {
+			return err
+		}
+		for i := range fresh.Provenance {
+			fresh.Provenance[i].EntryID = entry.ID
+		}
+		return tx.Create(&fresh.Provenance).Error
+	})
+	if err != nil {
+		log.Errorf(f+"failed to save re-enriched entry %d: %v", entry.ID, err)
+		return
+	}
+	if verified {
+		log.Debugf(f+"entry %d verified, re-enrichment skipped", entry.ID)
+		return
+	}
+	log.Debugf(f+"entry %d re-enriched", entry.ID)
+}
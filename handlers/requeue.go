@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"people/kafka"
+	"people/logging"
+	"people/models"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// The Redis key of the next offset of the fail topic to requeue.
+const requeueOffsetKey = "failures:offset"
+
+// The Redis key of the set of the offsets already requeued after the next
+// offset, which are not sent again.
+const requeueSentKey = "failures:sent"
+
+// The prefixes of the failure reasons that may succeed on a retry. The
+// validation, duplicate and implausible data failures are permanent.
+var transientErrors = []string{
+	"Read-only mode",
+	"Failed to enrich data from API",
+	"Failed to create entry",
+}
+
+// The function reports whether the failure reason is transient.
+func transient(reason string) bool {
+	for _, prefix := range transientErrors {
+		if strings.HasPrefix(reason, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// The function reads up to limit messages of the fail topic after the
+// offset stored in Redis and re-produces the transient failures without
+// the error into their source data topic with all their input fields.
+// The offset is advanced up to the first message failed to be sent, so
+// it is read again next time, while the messages sent after it are
+// remembered and not sent twice. Returns the numbers of the requeued,
+// skipped and failed to be sent messages, otherwise an error with its
+// cause.
+func RequeueFailures(limit int) (int, int, int, error) {
+	f := logging.F()
+	if len(dataTopics) == 0 || failProducer == nil {
+		return 0, 0, 0, errors.New("kafka consumer is not started")
+	}
+	offset, err := cRedis.Get(ctx, nsKey(requeueOffsetKey)).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, 0, 0, err
+	}
+	if err == redis.Nil {
+		offset = -1
+	}
+	members, err := cRedis.SMembers(ctx, nsKey(requeueSentKey)).Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	sent := make(map[int64]bool, len(members))
+	for _, member := range members {
+		if value, err := strconv.ParseInt(member, 10, 64); err == nil {
+			sent[value] = true
+		}
+	}
+	values, next, err := failTopic.Fetch(offset, limit)
+	if err != nil {
+		log.Error(f+"failed to read the fail topic: ", err)
+	}
+	requeued, skipped, failedSends := 0, 0, 0
+	batches := make(map[string][][]byte)
+	indexes := make(map[string][]int)
+	for i, msg := range values {
+		if sent[msg.Offset] {
+			continue
+		}
+		var failed models.FullName
+		err := json.Unmarshal(msg.Value, &failed)
+		if err != nil || !transient(failed.Error) {
+			skipped++
+			continue
+		}
+		jsonData, err := json.Marshal(struct {
+			Name        string `json:"name"`
+			Surname     string `json:"surname"`
+			Patronymic  string `json:"patronymic,omitempty"`
+			Age         uint8  `json:"age,omitempty"`
+			Gender      string `json:"gender,omitempty"`
+			Nationality string `json:"nationality,omitempty"`
+		}{
+			failed.Name,
+			failed.Surname,
+			failed.Patronymic,
+			failed.Age,
+			failed.Gender,
+			failed.Nationality,
+		})
+		if err != nil {
+			log.Error(f+"serializing to JSON failed: ", err)
+			skipped++
+			continue
+		}
+		topic := dataTopic(failed.Source).Name
+		batches[topic] = append(batches[topic], jsonData)
+		indexes[topic] = append(indexes[topic], i)
+	}
+	unsent := len(values)
+	var delivered []int
+	for name, batch := range batches {
+		errs := dataTopic(name).ProduceBatch(batch, syncProducer)
+		for i, err := range errs {
+			if err != nil {
+				failedSends++
+				unsent = min(unsent, indexes[name][i])
+				continue
+			}
+			requeued++
+			delivered = append(delivered, indexes[name][i])
+		}
+	}
+	if unsent < len(values) {
+		next = values[unsent].Offset
+		var offsets []interface{}
+		for _, i := range delivered {
+			if i > unsent {
+				offsets = append(offsets, values[i].Offset)
+			}
+		}
+		if len(offsets) > 0 {
+			err := cRedis.SAdd(ctx, nsKey(requeueSentKey), offsets...).Err()
+			if err != nil {
+				log.Error(f+"failed to save the requeued offsets: ", err)
+			}
+		}
+	}
+	var passed []interface{}
+	for value := range sent {
+		if value < next {
+			passed = append(passed, value)
+		}
+	}
+	if len(passed) > 0 {
+		err := cRedis.SRem(ctx, nsKey(requeueSentKey), passed...).Err()
+		if err != nil {
+			log.Error(f+"failed to clear the requeued offsets: ", err)
+		}
+	}
+	if len(values) > 0 {
+		err := cRedis.Set(ctx, nsKey(requeueOffsetKey), next, 0).Err()
+		if err != nil {
+			log.Error(f+"failed to save the fail topic offset: ", err)
+		}
+	}
+	if err == nil && failedSends > 0 {
+		err = fmt.Errorf("failed to send %d messages", failedSends)
+	}
+	log.Infof(
+		f+"failures requeued: %d, skipped: %d, failed: %d",
+		requeued,
+		skipped,
+		failedSends,
+	)
+	return requeued, skipped, failedSends, err
+}
+
+// The function returns the data topic by the name, otherwise the first
+// data topic.
+func dataTopic(name string) kafka.Topic {
+	for _, topic := range dataTopics {
+		if topic.Name == name {
+			return topic
+		}
+	}
+	return dataTopics[0]
+}
+
+// This API handler requeues up to "limit" transient failures of the
+// fail topic. Requires the administrator token. Return a JSON message
+// with the numbers of the requeued, skipped and failed messages or an
+// error with its cause if nothing was requeued.
+func Requeue(c *gin.Context) {
+	f := logging.F()
+	if !isAdmin(c) {
+		sendError(c, 401, models.CodeUnauthorized, "Unauthorized", nil)
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		log.Debug(f+"invalid limit: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid limit parameter", err)
+		return
+	}
+	requeued, skipped, failed, err := RequeueFailures(limit)
+	if err != nil {
+		log.Error(f+"failed to requeue failures: ", err)
+		if requeued == 0 {
+			sendError(c, 500, models.CodeInternal, "Failed to requeue", nil)
+			return
+		}
+	}
+	c.JSON(200, gin.H{
+		"requeued": requeued,
+		"skipped":  skipped,
+		"failed":   failed,
+	})
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"os"
+	"people/logging"
+	"people/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The middleware caps the number of the concurrent requests by the
+// REQUEST_MAX_CONCURRENT variable, 0 disables the limit. The requests
+// above the cap are shed with 503 and the Retry-After header instead of
+// queuing, so the database and the providers are not overloaded. The
+// exempt route paths are never shed and are not counted.
+func Shed(exempt ...string) gin.HandlerFunc {
+	max := 0
+	if value := os.Getenv("REQUEST_MAX_CONCURRENT"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			log.Fatalf("Failed to parse concurrent requests limit: %v", err)
+		}
+		max = parsed
+	}
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+	sem := make(chan struct{}, max)
+	return func(c *gin.Context) {
+		f := logging.F()
+		if max == 0 || skip[c.FullPath()] {
+			c.Next()
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			c.Next()
+		default:
+			log.Warn(f+"request shed: ", c.Request.URL.Path)
+			c.Header("Retry-After", "1")
+			sendError(c, 503, models.CodeOverloaded, "Server overloaded", nil)
+			c.Abort()
+		}
+	}
+}
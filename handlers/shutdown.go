@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"people/logging"
+	"sync/atomic"
+	"time"
+)
+
+// The number of the cache writes in progress, waited for on shutdown so
+// that the written entries and the generation bumps are not lost.
+var cacheOps atomic.Int64
+
+// The function runs the pending coalesced invalidation, waits up to the
+// timeout for the cache writes in progress and pings Redis to make sure
+// they were delivered, otherwise returns an error with its cause.
+func FlushCache(timeout time.Duration) error {
+	ingestInvalidation.flush(logging.F())
+	deadline := time.Now().Add(timeout)
+	for cacheOps.Load() > 0 {
+		if time.Now().After(deadline) {
+			return errors.New("cache writes did not finish in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return cRedis.Ping(ctx).Err()
+}
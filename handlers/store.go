@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	db "people/database"
+	"people/models"
+)
+
+// The Store interface abstracts the entries operations of the handlers
+// by the condition from GORM, so they can be tested against a fake
+// store: the writes, the lookups of the entries by their IDs and the
+// duplicates. The filtered pages, the merge transaction, the backups and
+// the maintenance jobs build their queries on db.C directly.
+type Store interface {
+	Create(ctx context.Context, entry *models.Entry) error
+	CreateBatch(ctx context.Context, entries []models.Entry) error
+	First(
+		ctx context.Context,
+		entry *models.Entry,
+		cond string,
+		args ...interface{},
+	) error
+	Find(
+		ctx context.Context,
+		entries *[]models.Entry,
+		cond string,
+		args ...interface{},
+	) error
+	Update(
+		ctx context.Context,
+		cond string,
+		arg interface{},
+		fields map[string]interface{},
+	) error
+	UpdateIf(
+		ctx context.Context,
+		cond string,
+		arg interface{},
+		where map[string]interface{},
+		fields map[string]interface{},
+	) (int64, error)
+	Delete(ctx context.Context, entry *models.Entry) error
+}
+
+// The default Store implementation on the db.C connection.
+type GormStore struct{}
+
+// The method inserts the entry with its associations within the
+// context.
+func (GormStore) Create(ctx context.Context, entry *models.Entry) error {
+	return db.C.WithContext(ctx).Create(entry).Error
+}
+
+// The method inserts the entries in batches of DB_BATCH_SIZE within the
+// context.
+func (GormStore) CreateBatch(
+	ctx context.Context,
+	entries []models.Entry,
+) error {
+	size, err := db.BatchSize(db.C, &models.Entry{})
+	if err != nil {
+		return err
+	}
+	return db.C.WithContext(ctx).CreateInBatches(&entries, size).Error
+}
+
+// The method reads the first entry by the ID matching the condition
+// within the context.
+func (GormStore) First(
+	ctx context.Context,
+	entry *models.Entry,
+	cond string,
+	args ...interface{},
+) error {
+	return db.C.WithContext(ctx).
+		First(entry, append([]interface{}{cond}, args...)...).
+		Error
+}
+
+// The method reads the entries matching the condition in the order of
+// their IDs within the context.
+func (GormStore) Find(
+	ctx context.Context,
+	entries *[]models.Entry,
+	cond string,
+	args ...interface{},
+) error {
+	return db.C.WithContext(ctx).
+		Where(cond, args...).
+		Order("id").
+		Find(entries).
+		Error
+}
+
+// The method updates the fields of the entries matching the condition
+// within the context.
+func (GormStore) Update(
+	ctx context.Context,
+	cond string,
+	arg interface{},
+	fields map[string]interface{},
+) error {
+	return db.C.WithContext(ctx).
+		Model(&models.Entry{}).
+		Where(cond, arg).
+		Updates(fields).
+		Error
+}
+
+// The method updates the fields of the entries matching the condition
+// and the expected current values within the context. Returns the number
+// of the updated entries, otherwise an error.
+func (GormStore) UpdateIf(
+	ctx context.Context,
+	cond string,
+	arg interface{},
+	where map[string]interface{},
+	fields map[string]interface{},
+) (int64, error) {
+	result := db.C.WithContext(ctx).
+		Model(&models.Entry{}).
+		Where(cond, arg).
+		Where(where).
+		Updates(fields)
+	return result.RowsAffected, result.Error
+}
+
+// The method soft deletes the entry within the context.
+func (GormStore) Delete(ctx context.Context, entry *models.Entry) error {
+	return db.C.WithContext(ctx).Delete(entry).Error
+}
+
+var store Store = GormStore{}
+
+// The function injects the entries store of the handlers, nil restores
+// the default GORM store.
+func SetStore(s Store) {
+	if s == nil {
+		s = GormStore{}
+	}
+	store = s
+}
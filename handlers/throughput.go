@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"fmt"
+	"people/models"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The number of the one-second buckets of the throughput counters.
+const throughputSeconds = 15 * 60
+
+// The windows of the throughput reported by the Throughput() handler.
+var throughputWindows = []struct {
+	name    string
+	seconds int64
+}{
+	{"1m", 60},
+	{"5m", 5 * 60},
+	{"15m", 15 * 60},
+}
+
+var throughput = &throughputCounters{}
+
+// The outcomes of the processed messages.
+const (
+	outcomeSuccess = "success"
+	outcomeFailed  = "failed"
+	outcomeSkipped = "skipped"
+)
+
+// The processed messages of one second.
+type throughputBucket struct {
+	second  int64
+	success int64
+	failed  int64
+	skipped int64
+}
+
+// The ring buffer of the messages processed by ProcessMsg() in the last
+// 15 minutes by the seconds.
+type throughputCounters struct {
+	mu      sync.Mutex
+	buckets [throughputSeconds]throughputBucket
+}
+
+// The method counts the processed message by its outcome.
+func (c *throughputCounters) record(outcome string) {
+	now := time.Now().Unix()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bucket := &c.buckets[now%throughputSeconds]
+	if bucket.second != now {
+		*bucket = throughputBucket{second: now}
+	}
+	switch outcome {
+	case outcomeSuccess:
+		bucket.success++
+	case outcomeFailed:
+		bucket.failed++
+	case outcomeSkipped:
+		bucket.skipped++
+	}
+}
+
+// The method returns the successful, the failed and the skipped messages
+// of the last seconds.
+func (c *throughputCounters) count(seconds int64) (int64, int64, int64) {
+	now := time.Now().Unix()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var success, failed, skipped int64
+	for _, bucket := range c.buckets {
+		if bucket.second > now-seconds && bucket.second <= now {
+			success += bucket.success
+			failed += bucket.failed
+			skipped += bucket.skipped
+		}
+	}
+	return success, failed, skipped
+}
+
+// The function counts the failed message by its reason.
+func countFailure(reason string) {
+	enrichFailures.add(reason)
+	throughput.record(outcomeFailed)
+}
+
+// The function counts the message skipped as a repeated or a duplicate
+// one.
+func countSkipped() {
+	throughput.record(outcomeSkipped)
+}
+
+// This API handler returns the number of the Apache Kafka messages
+// processed in the last 1, 5 and 15 minutes with the success, the
+// failure and the skip split and the rate per second. The "format" parameter
+// "prometheus" returns the rates as the gauges in the Prometheus text
+// format instead of JSON.
+func Throughput(c *gin.Context) {
+	type window struct {
+		Success int64   `json:"success"`
+		Failed  int64   `json:"failed"`
+		Skipped int64   `json:"skipped"`
+		Total   int64   `json:"total"`
+		Rate    float64 `json:"rate"`
+	}
+	windows := make(map[string]window, len(throughputWindows))
+	for _, w := range throughputWindows {
+		success, failed, skipped := throughput.count(w.seconds)
+		total := success + failed + skipped
+		windows[w.name] = window{
+			Success: success,
+			Failed:  failed,
+			Skipped: skipped,
+			Total:   total,
+			Rate:    float64(total) / float64(w.seconds),
+		}
+	}
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(200, gin.H{"windows": windows})
+	case "prometheus":
+		var b strings.Builder
+		b.WriteString("# HELP people_kafka_messages_rate " +
+			"Processed messages per second by the outcome.\n")
+		b.WriteString("# TYPE people_kafka_messages_rate gauge\n")
+		for _, w := range throughputWindows {
+			counts := windows[w.name]
+			for _, outcome := range []struct {
+				name  string
+				count int64
+			}{
+				{"success", counts.Success},
+				{"failed", counts.Failed},
+				{"skipped", counts.Skipped},
+			} {
+				fmt.Fprintf(
+					&b,
+					"people_kafka_messages_rate{window=%q,outcome=%q} %g\n",
+					w.name,
+					outcome.name,
+					float64(outcome.count)/float64(w.seconds),
+				)
+			}
+		}
+		c.Data(200, "text/plain; version=0.0.4", []byte(b.String()))
+	default:
+		sendError(
+			c, 400, models.CodeBadRequest, "Invalid format parameter", nil,
+		)
+	}
+}
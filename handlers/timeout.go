@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"people/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The middleware limits the request processing time with the deadline
+// of the request context. The REQUEST_TIMEOUT variable sets the default,
+// 30 seconds if empty, the routes map overrides it by the route path and
+// the zero duration disables the limit. The handlers exceeding the
+// deadline are answered with 504.
+func Timeout(routes map[string]time.Duration) gin.HandlerFunc {
+	def := 30 * time.Second
+	if value := os.Getenv("REQUEST_TIMEOUT"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Failed to parse request timeout: %v", err)
+		}
+		def = parsed
+	}
+	return func(c *gin.Context) {
+		timeout, ok := routes[c.FullPath()]
+		if !ok {
+			timeout = def
+		}
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		if timedOut(c) && !c.Writer.Written() {
+			sendError(c, 504, models.CodeTimeout, "Request timed out", nil)
+		}
+	}
+}
+
+// The function reports whether the request deadline is exceeded.
+func timedOut(c *gin.Context) bool {
+	return errors.Is(c.Request.Context().Err(), context.DeadlineExceeded)
+}
+
+// The function reports whether the request is cancelled by the client,
+// so there is nobody to respond to.
+func cancelled(c *gin.Context) bool {
+	return errors.Is(c.Request.Context().Err(), context.Canceled)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"os"
+	"people/logging"
+	"people/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The validation result of the entry in the batch by its index.
+type validation struct {
+	Index  int      `json:"index"`
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// This API handler checks up to VALIDATE_BATCH_MAX entries like the
+// Create() handler without saving them or touching the cache. Return a
+// JSON message with the results by the entry indexes and the numbers of
+// the valid and invalid entries or an error with its cause.
+func ValidateBatch(c *gin.Context) {
+	f := logging.F()
+	var items []json.RawMessage
+	if err := c.ShouldBindJSON(&items); err != nil {
+		log.Debug(f+"parsing failed: ", err)
+		sendError(c, 400, models.CodeBadRequest, "Invalid API query", err)
+		return
+	}
+	max, err := strconv.Atoi(os.Getenv("VALIDATE_BATCH_MAX"))
+	if err != nil || max < 1 {
+		max = 1000
+	}
+	if len(items) > max {
+		sendError(
+			c,
+			413,
+			models.CodeTooLarge,
+			"Too many entries, the maximum is "+strconv.Itoa(max),
+			nil,
+		)
+		return
+	}
+	results := make([]validation, len(items))
+	valid := 0
+	for i, item := range items {
+		results[i].Index = i
+		var entry models.Entry
+		if err := json.Unmarshal(item, &entry); err != nil {
+			results[i].Errors = []string{err.Error()}
+			continue
+		}
+		entry.Normalize()
+		results[i].Errors = entry.Causes()
+		if len(results[i].Errors) == 0 {
+			results[i].Valid = true
+			valid++
+		}
+	}
+	c.JSON(200, gin.H{
+		"results": results,
+		"valid":   valid,
+		"invalid": len(items) - valid,
+	})
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"people/models"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The queue size of the deliveries of every webhook. Deliveries over a
+// full queue are dropped so that a slow webhook never blocks the writes.
+const webhookQueue = 256
+
+var webhooks = notifier{
+	queues: make(map[string]chan []byte),
+	client: &http.Client{Timeout: 10 * time.Second},
+}
+
+// The background delivery of the created entries to the webhooks. Every
+// webhook has its own queue and worker, so a slow one doesn't delay the
+// others.
+type notifier struct {
+	mu      sync.Mutex
+	queues  map[string]chan []byte
+	client  *http.Client
+	dropped atomic.Int64
+}
+
+// The function checks that the webhooks from the WEBHOOK_URLS
+// environment variable are signed with a non-empty WEBHOOK_SECRET, so
+// the server stops at startup instead of sending the unsigned deliveries.
+func CheckWebhooks() error {
+	if os.Getenv("WEBHOOK_URLS") != "" && os.Getenv("WEBHOOK_SECRET") == "" {
+		return errors.New("WEBHOOK_SECRET is required by WEBHOOK_URLS")
+	}
+	return nil
+}
+
+// The function queues the created entry for the webhooks from the
+// WEBHOOK_URLS environment variable. Does nothing without webhooks.
+func notifyCreated(entry models.Entry) {
+	if os.Getenv("WEBHOOK_URLS") == "" {
+		return
+	}
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		log.Error("Webhook serializing to JSON failed: ", err)
+		return
+	}
+	for _, url := range strings.Split(os.Getenv("WEBHOOK_URLS"), ",") {
+		if url = strings.TrimSpace(url); url == "" {
+			continue
+		}
+		select {
+		case webhooks.queue(url) <- payload:
+		default:
+			log.Warnf(
+				"Webhook %s delivery of entry %v dropped, %d in total",
+				url,
+				entry.PublicID(),
+				webhooks.dropped.Add(1),
+			)
+		}
+	}
+}
+
+// The method returns the delivery queue of the webhook and starts its
+// worker on the first use.
+func (n *notifier) queue(url string) chan []byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	queue, ok := n.queues[url]
+	if !ok {
+		queue = make(chan []byte, webhookQueue)
+		n.queues[url] = queue
+		go n.run(url, queue)
+	}
+	return queue
+}
+
+// The method delivers the queued payloads to the webhook.
+func (n *notifier) run(url string, queue chan []byte) {
+	for payload := range queue {
+		n.deliver(url, payload)
+	}
+}
+
+// The method posts the payload to the webhook and retries failures
+// WEBHOOK_RETRIES times, doubling the WEBHOOK_BACKOFF delay.
+func (n *notifier) deliver(url string, payload []byte) {
+	retries, err := strconv.Atoi(os.Getenv("WEBHOOK_RETRIES"))
+	if err != nil || retries < 0 {
+		retries = 3
+	}
+	backoff, err := time.ParseDuration(os.Getenv("WEBHOOK_BACKOFF"))
+	if err != nil || backoff <= 0 {
+		backoff = time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		err := n.post(url, payload)
+		if err == nil {
+			log.Debugf("Webhook %s delivered", url)
+			return
+		}
+		if attempt >= retries {
+			log.Errorf("Webhook %s delivery failed: %v", url, err)
+			return
+		}
+		log.Warnf("Webhook %s attempt %d failed: %v", url, attempt+1, err)
+		time.Sleep(backoff << attempt)
+	}
+}
+
+// The method sends the payload signed with WEBHOOK_SECRET, otherwise
+// returns an error with its cause.
+func (n *notifier) post(url string, payload []byte) error {
+	request, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(
+		"X-Signature",
+		sign(payload, os.Getenv("WEBHOOK_SECRET")),
+	)
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// The function returns the HMAC-SHA256 signature of the payload.
+func sign(payload []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,324 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// The consumed message with its offset and the acknowledgement of its
+// processing, nil for the fetched messages. The offset of the message
+// is committed only after it and all the earlier messages of the
+// partition are acknowledged.
+type Message struct {
+	Value  []byte
+	Offset int64
+	Ack    func()
+}
+
+// The settings of the consumer offset commits.
+type CommitConfig struct {
+	Group    string
+	Every    int
+	Interval time.Duration
+}
+
+// The function reads the consumer group and the commit batching from
+// the AK_GROUP, AK_COMMIT_EVERY and AK_COMMIT_INTERVAL environment
+// variables, otherwise returns an error. The offsets are committed
+// after every N processed messages or every T, whichever comes first,
+// zero interval disables the timer.
+func Commits() (CommitConfig, error) {
+	commits := CommitConfig{
+		Group:    os.Getenv("AK_GROUP"),
+		Every:    100,
+		Interval: time.Second,
+	}
+	if commits.Group == "" {
+		commits.Group = "people"
+	}
+	if value := os.Getenv("AK_COMMIT_EVERY"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return commits, fmt.Errorf("invalid AK_COMMIT_EVERY %q", value)
+		}
+		commits.Every = n
+	}
+	if value := os.Getenv("AK_COMMIT_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			return commits, fmt.Errorf(
+				"invalid AK_COMMIT_INTERVAL %q", value,
+			)
+		}
+		commits.Interval = interval
+	}
+	return commits, nil
+}
+
+// The tracker of the consumed offsets of a claimed partition. The offset
+// is marked once it and all the earlier ones are acknowledged, so the
+// failure before the commit redelivers the unprocessed messages.
+type commitTracker struct {
+	mu        sync.Mutex
+	session   sarama.ConsumerGroupSession
+	topic     string
+	partition int32
+	metadata  string
+	every     int
+	pending   []int64
+	acked     map[int64]bool
+	marked    int
+}
+
+// The method registers the consumed offset awaiting the acknowledgement.
+func (t *commitTracker) add(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, offset)
+}
+
+// The method acknowledges the processed offset, marks the acknowledged
+// prefix of the pending offsets and commits them every N marked ones.
+func (t *commitTracker) ack(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.acked[offset] = true
+	for len(t.pending) > 0 && t.acked[t.pending[0]] {
+		delete(t.acked, t.pending[0])
+		t.session.MarkOffset(
+			t.topic, t.partition, t.pending[0]+1, t.metadata,
+		)
+		t.pending = t.pending[1:]
+		t.marked++
+	}
+	if t.marked >= t.every {
+		t.commit()
+	}
+}
+
+// The method commits the marked offsets, if any.
+func (t *commitTracker) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.marked > 0 {
+		t.commit()
+	}
+}
+
+// The method commits the marked offsets synchronously. The caller holds
+// the lock.
+func (t *commitTracker) commit() {
+	t.session.Commit()
+	t.marked = 0
+}
+
+// The handler of the consumer group sessions of the topic. The group
+// balances the partitions among the consumers sharing the AK_GROUP, so
+// every message is processed by one of them.
+type groupHandler struct {
+	topic    Topic
+	client   sarama.Client
+	group    string
+	every    int
+	data     chan Message
+	mu       sync.Mutex
+	trackers map[int32]*commitTracker
+}
+
+// The method resets the claimed partitions before their consumption.
+// The AK_START_TIMESTAMP replay takes precedence over the offset
+// committed before it, the committed offsets carry the timestamp as the
+// metadata, so the partition is replayed once by any consumer of the
+// group. The expired committed offset falls back to the oldest one.
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	manager, err := sarama.NewOffsetManagerFromClient(h.group, h.client)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+	replay := os.Getenv("AK_START_TIMESTAMP")
+	reset := false
+	for _, partition := range session.Claims()[h.topic.Name] {
+		offset, err := h.startOffset(manager, partition, replay)
+		if err != nil {
+			return err
+		}
+		if offset < 0 {
+			continue
+		}
+		// The reset moves the offset backwards only, the mark forwards only.
+		session.ResetOffset(h.topic.Name, partition, offset, replay)
+		session.MarkOffset(h.topic.Name, partition, offset, replay)
+		reset = true
+	}
+	if reset {
+		session.Commit()
+	}
+	return nil
+}
+
+// The method returns the offset the claimed partition is reset to or a
+// negative one to resume from the committed offset.
+func (h *groupHandler) startOffset(
+	manager sarama.OffsetManager,
+	partition int32,
+	replay string,
+) (int64, error) {
+	name := h.topic.Name
+	pom, err := manager.ManagePartition(name, partition)
+	if err != nil {
+		return 0, err
+	}
+	committed, metadata := pom.NextOffset()
+	pom.Close()
+	if replay != "" && metadata != replay {
+		offset, err := StartOffset(h.client, name, partition)
+		if err != nil {
+			return 0, err
+		}
+		if offset == sarama.OffsetNewest {
+			return h.client.GetOffset(name, partition, sarama.OffsetNewest)
+		}
+		return offset, nil
+	}
+	if committed < 0 {
+		return -1, nil
+	}
+	oldest, err := h.client.GetOffset(name, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	if committed < oldest {
+		return oldest, nil
+	}
+	return -1, nil
+}
+
+// The method forwards the messages of the claimed partition with their
+// acknowledgements until the session ends.
+func (h *groupHandler) ConsumeClaim(
+	session sarama.ConsumerGroupSession,
+	claim sarama.ConsumerGroupClaim,
+) error {
+	tracker := &commitTracker{
+		session:   session,
+		topic:     claim.Topic(),
+		partition: claim.Partition(),
+		metadata:  os.Getenv("AK_START_TIMESTAMP"),
+		every:     h.every,
+		acked:     make(map[int64]bool),
+	}
+	h.mu.Lock()
+	h.trackers[claim.Partition()] = tracker
+	h.mu.Unlock()
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			offset := msg.Offset
+			tracker.add(offset)
+			select {
+			case h.data <- Message{
+				Value:  msg.Value,
+				Offset: offset,
+				Ack:    func() { tracker.ack(offset) },
+			}:
+			case <-session.Context().Done():
+				return nil
+			}
+			log.Debugf("%s message: %v\n", h.topic.Name, msg)
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// The method commits the marked offsets of the ended session.
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for partition, tracker := range h.trackers {
+		tracker.flush()
+		delete(h.trackers, partition)
+	}
+	return nil
+}
+
+// The method commits the marked offsets of the claimed partitions.
+func (h *groupHandler) flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, tracker := range h.trackers {
+		tracker.flush()
+	}
+}
+
+// The method creates a member of the AK_GROUP consumer group reading
+// the Apache Kafka messages of the topic partitions assigned to it and
+// committing their offsets in batches of the Commits() settings. The
+// consumption of the partition resumes from its committed offset,
+// without one from the newest offset. The AK_START_TIMESTAMP replays the
+// partitions once. Every message must be acknowledged after its
+// processing for the at-least-once delivery.
+func (arg Topic) ConsumeCommitted(data chan Message) {
+	commits, err := Commits()
+	if err != nil {
+		log.Fatalf("Failed to configure commits: %v", err)
+	}
+	config, err := ConsumerConfig()
+	if err != nil {
+		log.Fatalf("Failed to configure consumer: %v", err)
+	}
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	client, err := sarama.NewClient(address, config)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	group, err := sarama.NewConsumerGroupFromClient(commits.Group, client)
+	if err != nil {
+		log.Fatalf("Failed to create consumer group: %v", err)
+	}
+	go func() {
+		for err := range group.Errors() {
+			log.Errorf("%s error consuming message: %v\n", arg.Name, err)
+		}
+	}()
+	handler := &groupHandler{
+		topic:    arg,
+		client:   client,
+		group:    commits.Group,
+		every:    commits.Every,
+		data:     data,
+		trackers: make(map[int32]*commitTracker),
+	}
+	if commits.Interval > 0 {
+		go func() {
+			ticker := time.NewTicker(commits.Interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				handler.flush()
+			}
+		}()
+	}
+	log.Infof("Awaiting data from %s for %s...", arg.Name, commits.Group)
+	for {
+		err := group.Consume(context.Background(), []string{arg.Name}, handler)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			return
+		}
+		if err != nil {
+			log.Errorf("%s consumer group failed: %v", arg.Name, err)
+			time.Sleep(time.Second)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package kafka
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"people/logging"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
 	_ "github.com/joho/godotenv/autoload"
@@ -15,26 +20,117 @@ var (
 )
 
 // The function initializes the Apache Kafka connection data from the
-// environment variables and triggers the creation of topics.
-func Start(topics Topics) {
+// environment variables and triggers the creation of topics, otherwise
+// returns an error. The topics listed twice are rejected before the
+// connection.
+func Start(topics Topics) error {
 	address = strings.Split(os.Getenv("AK_ADDR"), ",")
-	topics.Create()
+	err := topics.Unique()
+	if err != nil {
+		return err
+	}
+	return topics.Create()
 }
 
 type Topics []Topic
 
-// The method creates Apache Kafka topics based on structure data.
-func (args Topics) Create() {
+// The method checks that every topic is listed once, otherwise returns
+// an error with the repeated name.
+func (args Topics) Unique() error {
+	seen := make(map[string]bool, len(args))
+	for _, v := range args {
+		if seen[v.Name] {
+			return fmt.Errorf("topic %q is listed twice", v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return nil
+}
+
+// The function checks the roles of the topics. The data topics and the
+// fail topic must be named and distinct, otherwise the failed messages
+// would be consumed again in an endless loop.
+func CheckRoles(data Topics, fail Topic) error {
+	switch {
+	case len(data) == 0:
+		return errors.New("no data topics are set")
+	case fail.Name == "":
+		return errors.New("fail topic name is empty")
+	}
+	for _, v := range data {
+		switch v.Name {
+		case "":
+			return errors.New("data topic name is empty")
+		case fail.Name:
+			return fmt.Errorf("topic %q is both data and fail topic", v.Name)
+		}
+	}
+	return data.Unique()
+}
+
+// The function checks the role of the optional result topic. It must
+// differ from the data topics and the fail topic, otherwise the results
+// would be consumed again or mixed with the failures.
+func CheckResult(data Topics, fail Topic, result Topic) error {
+	if result.Name == "" {
+		return nil
+	}
+	if result.Name == fail.Name {
+		return fmt.Errorf("topic %q is both fail and result topic", fail.Name)
+	}
+	for _, v := range data {
+		if v.Name == result.Name {
+			return fmt.Errorf(
+				"topic %q is both data and result topic", v.Name,
+			)
+		}
+	}
+	return nil
+}
+
+// The function creates topics with the same settings from the
+// comma-separated list of names, skipping the empty ones.
+func Parse(names string, partitions int32, replication int16) Topics {
+	var topics Topics
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		topics = append(topics, Topic{
+			Name:        name,
+			Partitions:  partitions,
+			Replication: replication,
+		})
+	}
+	return topics
+}
+
+// The method creates Apache Kafka topics based on structure data. The
+// settings are validated against the broker metadata before creating,
+// an existing topic is not an error.
+func (args Topics) Create() error {
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
 	client, err := sarama.NewClient(address, config)
 	if err != nil {
-		log.Fatal("Failed to create client: ", err)
+		return fmt.Errorf("failed to create client: %w", err)
 	}
 	defer client.Close()
+	brokers := len(client.Brokers())
+	maxPartitions, err := maxPartitions()
+	if err != nil {
+		return err
+	}
+	for _, v := range args {
+		err = v.Validate(brokers, maxPartitions)
+		if err != nil {
+			return err
+		}
+	}
 	admin, err := sarama.NewClusterAdminFromClient(client)
 	if err != nil {
-		log.Fatal("Failed to create admin client: ", err)
+		return fmt.Errorf("failed to create admin client: %w", err)
 	}
 	defer admin.Close()
 	for _, v := range args {
@@ -43,49 +139,284 @@ func (args Topics) Create() {
 			ReplicationFactor: v.Replication,
 		}
 		topicName := v.Name
-		err = admin.CreateTopic(topicName, topicDetail, false)
+		err = CheckCreate(
+			topicName,
+			admin.CreateTopic(topicName, topicDetail, false),
+		)
 		if err != nil {
-			log.Infof("Topic creating info: %s", err)
-		} else {
-			log.Infof("Topic '%s' created.", topicName)
+			return err
 		}
 	}
+	return nil
+}
+
+// The function classifies the result of the topic creation. The topic
+// that already exists is logged at the debug level, the genuine
+// failures are logged and returned as an error.
+func CheckCreate(name string, err error) error {
+	switch {
+	case errors.Is(err, sarama.ErrTopicAlreadyExists):
+		log.Debugf("Topic '%s' already exists.", name)
+		return nil
+	case err != nil:
+		log.Errorf("Failed to create topic '%s': %v", name, err)
+		return fmt.Errorf("failed to create topic %s: %w", name, err)
+	}
+	log.Infof("Topic '%s' created.", name)
+	return nil
+}
+
+// The function reads the maximum number of the topic partitions from
+// the environment variable. Zero or empty value disables the limit.
+func maxPartitions() (int32, error) {
+	value := os.Getenv("AK_MAX_PARTITIONS")
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid AK_MAX_PARTITIONS %q", value)
+	}
+	return int32(limit), nil
 }
 
 type Topic struct {
 	Name        string
 	Partitions  int32
 	Replication int16
+	Partitioner Partitioner // LastPartition if nil
+}
+
+// The method checks the topic settings against the number of available
+// brokers and the maximum number of partitions, otherwise returns an
+// error with the cause.
+func (arg Topic) Validate(brokers int, maxPartitions int32) error {
+	switch {
+	case arg.Name == "":
+		return errors.New("topic name is empty")
+	case arg.Partitions < 1:
+		return fmt.Errorf(
+			"topic %s: partitions must be positive, got %d",
+			arg.Name,
+			arg.Partitions,
+		)
+	case maxPartitions > 0 && arg.Partitions > maxPartitions:
+		return fmt.Errorf(
+			"topic %s: %d partitions exceed the maximum of %d",
+			arg.Name,
+			arg.Partitions,
+			maxPartitions,
+		)
+	case arg.Replication < 1:
+		return fmt.Errorf(
+			"topic %s: replication factor must be positive, got %d",
+			arg.Name,
+			arg.Replication,
+		)
+	case int(arg.Replication) > brokers:
+		return fmt.Errorf(
+			"topic %s: replication factor %d exceeds %d available brokers",
+			arg.Name,
+			arg.Replication,
+			brokers,
+		)
+	}
+	return nil
 }
 
 // The method creates a consumer and consume of the Apache Kafka
-// messages.
+// messages of every topic partition from the newest offset or from the
+// AK_START_TIMESTAMP.
 func (arg Topic) Consume(data chan []byte) {
-	config := sarama.NewConfig()
-	config.Consumer.Return.Errors = true
-	consumer, err := sarama.NewConsumer(address, config)
+	config, err := ConsumerConfig()
+	if err != nil {
+		log.Fatalf("Failed to configure consumer: %v", err)
+	}
+	client, err := sarama.NewClient(address, config)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
 	if err != nil {
 		log.Fatalf("Failed to create consumer: %v", err)
 	}
-	reader, err := consumer.ConsumePartition(
-		arg.Name, arg.Partitions-1, sarama.OffsetNewest,
-	)
+	partitions, err := consumer.Partitions(arg.Name)
 	if err != nil {
-		log.Fatalf("Failed to create ConsumePartition %s: %v", arg.Name, err)
+		log.Fatalf("Failed to get partitions of %s: %v", arg.Name, err)
+	}
+	var wg sync.WaitGroup
+	for _, partition := range partitions {
+		offset, err := StartOffset(client, arg.Name, partition)
+		if err != nil {
+			log.Fatalf("Failed to get start offset: %v", err)
+		}
+		reader, err := consumer.ConsumePartition(arg.Name, partition, offset)
+		if err != nil {
+			log.Fatalf(
+				"Failed to create ConsumePartition %s: %v", arg.Name, err,
+			)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			arg.forward(reader, func(msg *sarama.ConsumerMessage) {
+				data <- msg.Value
+			})
+		}()
 	}
-	defer reader.Close()
 	log.Infof("Awaiting data from %s...", arg.Name)
+	wg.Wait()
+}
+
+// The method passes the messages of the partition to the delivery
+// function and logs the consuming errors until the reader is closed.
+func (arg Topic) forward(
+	reader sarama.PartitionConsumer,
+	deliver func(msg *sarama.ConsumerMessage),
+) {
+	defer reader.Close()
 	for {
 		select {
-		case msg := <-reader.Messages():
-			data <- msg.Value
+		case msg, ok := <-reader.Messages():
+			if !ok {
+				return
+			}
+			deliver(msg)
 			log.Debugf("%s message: %v\n", arg.Name, msg)
-		case err := <-reader.Errors():
+		case err, ok := <-reader.Errors():
+			if !ok {
+				return
+			}
 			log.Errorf("%s error consuming message: %v\n", arg.Name, err)
 		}
 	}
 }
 
+// The function returns the offset of the first message of the topic
+// partition produced at or after the AK_START_TIMESTAMP in RFC 3339 by
+// the broker offset-for-timestamp lookup. The empty timestamp or no
+// later message returns the newest offset, otherwise an error.
+func StartOffset(
+	client sarama.Client,
+	topic string,
+	partition int32,
+) (int64, error) {
+	value := os.Getenv("AK_START_TIMESTAMP")
+	if value == "" {
+		return sarama.OffsetNewest, nil
+	}
+	start, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid AK_START_TIMESTAMP %q", value)
+	}
+	offset, err := client.GetOffset(topic, partition, start.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get offset of %s: %w", topic, err)
+	}
+	log.Infof("%s starts from offset %d of %s", topic, offset, value)
+	return offset, nil
+}
+
+// The method reads up to limit messages of the topic from the offset
+// to the end of the last partition, where the messages without the
+// partitioner are produced. The negative or expired offset starts from
+// the oldest message. Returns the messages with their offsets and the
+// next offset, otherwise an error with its cause, also for the topic
+// partitioned by the partitioner, as its single offset cannot track
+// several partitions.
+func (arg Topic) Fetch(offset int64, limit int) ([]Message, int64, error) {
+	if arg.Partitioner != nil && arg.Partitions > 1 {
+		return nil, offset, fmt.Errorf(
+			"topic %s is partitioned and cannot be fetched", arg.Name,
+		)
+	}
+	config, err := ConsumerConfig()
+	if err != nil {
+		return nil, offset, err
+	}
+	client, err := sarama.NewClient(address, config)
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to create client: %w", err)
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+	partition := arg.Partitions - 1
+	oldest, err := client.GetOffset(arg.Name, partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, offset, fmt.Errorf(
+			"failed to get offset of %s: %w", arg.Name, err,
+		)
+	}
+	if offset < 0 {
+		offset = oldest
+	}
+	reader, err := consumer.ConsumePartition(arg.Name, partition, offset)
+	if errors.Is(err, sarama.ErrOffsetOutOfRange) {
+		offset = oldest
+		reader, err = consumer.ConsumePartition(arg.Name, partition, offset)
+	}
+	if err != nil {
+		return nil, offset, fmt.Errorf(
+			"failed to create ConsumePartition %s: %w", arg.Name, err,
+		)
+	}
+	defer reader.Close()
+	end := reader.HighWaterMarkOffset()
+	var values []Message
+	for len(values) < limit && offset < end {
+		select {
+		case msg := <-reader.Messages():
+			values = append(values, Message{
+				Value:  msg.Value,
+				Offset: msg.Offset,
+			})
+			offset = msg.Offset + 1
+		case err := <-reader.Errors():
+			return values, offset, err
+		case <-time.After(2 * config.Consumer.MaxWaitTime):
+			return values, offset, nil
+		}
+	}
+	return values, offset, nil
+}
+
+// The function creates the consumer configuration with the fetch sizing
+// from the AK_FETCH_MIN, AK_FETCH_DEFAULT and AK_FETCH_MAX_WAIT
+// environment variables, otherwise returns an error.
+func ConsumerConfig() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = true
+	for name, field := range map[string]*int32{
+		"AK_FETCH_MIN":     &config.Consumer.Fetch.Min,
+		"AK_FETCH_DEFAULT": &config.Consumer.Fetch.Default,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s %q", name, value)
+		}
+		*field = int32(n)
+	}
+	if value := os.Getenv("AK_FETCH_MAX_WAIT"); value != "" {
+		wait, err := time.ParseDuration(value)
+		if err != nil || wait <= 0 {
+			return nil, fmt.Errorf("invalid AK_FETCH_MAX_WAIT %q", value)
+		}
+		config.Consumer.MaxWaitTime = wait
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
 // The function create an async producer of the Apache Kafka messages.
 func NewProd() sarama.AsyncProducer {
 	config := sarama.NewConfig()
@@ -103,12 +434,71 @@ func NewProd() sarama.AsyncProducer {
 	return producer
 }
 
-// The method for produce a message to the topic.
+// The function create a sync producer of the Apache Kafka messages for
+// the batches, separate from the async producer of the single messages.
+func NewSyncProd() sarama.SyncProducer {
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Partitioner = sarama.NewManualPartitioner
+	config.Producer.Return.Successes = true
+	client, err := sarama.NewClient(address, config)
+	if err != nil {
+		log.Fatal("Failed to create client: ", err)
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		log.Fatal("Failed to create producer from client: ", err)
+	}
+	return producer
+}
+
+// The method produces the messages to the topic partitions chosen by
+// the topic partitioner without waiting for each acknowledgement. All
+// the messages are in flight at once and the call returns after all
+// the acknowledgements. Returns the error of each message by its index,
+// nil for the sent ones.
+func (arg Topic) ProduceBatch(
+	vals [][]byte,
+	prod sarama.SyncProducer,
+) []error {
+	messages := make([]*sarama.ProducerMessage, len(vals))
+	for i, val := range vals {
+		messages[i] = &sarama.ProducerMessage{
+			Topic:     arg.Name,
+			Value:     sarama.ByteEncoder(val),
+			Partition: arg.Partition(val),
+			Metadata:  i,
+		}
+	}
+	errs := make([]error, len(vals))
+	err := prod.SendMessages(messages)
+	var failed sarama.ProducerErrors
+	switch {
+	case errors.As(err, &failed):
+		for _, v := range failed {
+			log.Error("Failed to sent message: ", v)
+			if i, ok := v.Msg.Metadata.(int); ok && i < len(errs) {
+				errs[i] = v.Err
+			}
+		}
+	case err != nil:
+		log.Error("Failed to sent messages: ", err)
+		for i := range errs {
+			errs[i] = err
+		}
+	default:
+		log.Debugf("%d messages sent to %s\n", len(vals), arg.Name)
+	}
+	return errs
+}
+
+// The method for produce a message to the topic partition chosen by
+// the topic partitioner.
 func (arg Topic) Produce(val []byte, prod sarama.AsyncProducer) string {
 	message := &sarama.ProducerMessage{
 		Topic:     arg.Name,
 		Value:     sarama.ByteEncoder(val),
-		Partition: arg.Partitions - 1,
+		Partition: arg.Partition(val),
 	}
 	prod.Input() <- message
 	select {
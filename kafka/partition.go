@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"strings"
+	"sync/atomic"
+)
+
+// The function choosing the partition of the message by its value among
+// the number of the topic partitions.
+type Partitioner func(value []byte, partitions int32) int32
+
+// The partitioner of the last partition read by the consumers. It is
+// used for the topics without the partitioner.
+func LastPartition(value []byte, partitions int32) int32 {
+	return partitions - 1
+}
+
+// The function returns the partitioner of the explicit partition.
+func Explicit(partition int32) Partitioner {
+	return func(value []byte, partitions int32) int32 {
+		return partition
+	}
+}
+
+// The function returns the partitioner cycling through the partitions
+// in turn.
+func RoundRobin() Partitioner {
+	var next atomic.Uint32
+	return func(value []byte, partitions int32) int32 {
+		return int32((next.Add(1) - 1) % uint32(partitions))
+	}
+}
+
+// The function returns the partitioner hashing the routing key of the
+// message, so the messages with the equal keys share the partition.
+func HashBy(key func(value []byte) []byte) Partitioner {
+	return func(value []byte, partitions int32) int32 {
+		hash := fnv.New32a()
+		hash.Write(key(value))
+		return int32(hash.Sum32() % uint32(partitions))
+	}
+}
+
+// The routing key of the JSON message by its case-insensitive name.
+func NameKey(value []byte) []byte {
+	var msg struct {
+		Name string `json:"name"`
+	}
+	json.Unmarshal(value, &msg)
+	return []byte(strings.ToLower(strings.TrimSpace(msg.Name)))
+}
+
+// The method returns the partition of the message by the topic
+// partitioner. The partition out of the topic falls back to the last
+// one.
+func (arg Topic) Partition(value []byte) int32 {
+	if arg.Partitioner == nil {
+		return LastPartition(value, arg.Partitions)
+	}
+	partition := arg.Partitioner(value, arg.Partitions)
+	if partition < 0 || partition >= arg.Partitions {
+		log.Warnf(
+			"Partition %d is out of topic %s, the last one is used",
+			partition,
+			arg.Name,
+		)
+		return LastPartition(value, arg.Partitions)
+	}
+	return partition
+}
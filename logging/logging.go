@@ -2,15 +2,18 @@ package logging
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/sirupsen/logrus"
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
@@ -38,19 +41,39 @@ func Logger(env string) *logrus.Logger {
 	return log
 }
 
-// GORM-Logrus interface
-func GL(logger *logrus.Logger) logger.Interface {
+// GORM-Logrus interface with the slow query threshold, logging level
+// and record not found error ignoring settings.
+func GL(logger *logrus.Logger, config logger.Config) logger.Interface {
 	return &GormLogger{
 		logger: logger,
+		config: config,
 	}
 }
 
+// Parses the GORM logging level name: silent, error, warn or info.
+func GormLevel(level string) (logger.LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "silent":
+		return logger.Silent, nil
+	case "error":
+		return logger.Error, nil
+	case "warn":
+		return logger.Warn, nil
+	case "info":
+		return logger.Info, nil
+	}
+	return 0, fmt.Errorf("unknown GORM logging level %q", level)
+}
+
 type GormLogger struct {
 	logger *logrus.Logger
+	config logger.Config
 }
 
 func (l *GormLogger) LogMode(level logger.LogLevel) logger.Interface {
-	return l
+	newLogger := *l
+	newLogger.config.LogLevel = level
+	return &newLogger
 }
 
 func (l *GormLogger) Info(
@@ -58,7 +81,9 @@ func (l *GormLogger) Info(
 	msg string,
 	data ...interface{},
 ) {
-	l.logger.WithContext(ctx).Infof("[GORM] "+msg, data...)
+	if l.config.LogLevel >= logger.Info {
+		l.logger.WithContext(ctx).Infof("[GORM] "+msg, data...)
+	}
 }
 
 func (l *GormLogger) Warn(
@@ -66,7 +91,9 @@ func (l *GormLogger) Warn(
 	msg string,
 	data ...interface{},
 ) {
-	l.logger.WithContext(ctx).Warnf("[GORM] "+msg, data...)
+	if l.config.LogLevel >= logger.Warn {
+		l.logger.WithContext(ctx).Warnf("[GORM] "+msg, data...)
+	}
 }
 
 func (l *GormLogger) Error(
@@ -74,7 +101,9 @@ func (l *GormLogger) Error(
 	msg string,
 	data ...interface{},
 ) {
-	l.logger.WithContext(ctx).Errorf("[GORM] "+msg, data...)
+	if l.config.LogLevel >= logger.Error {
+		l.logger.WithContext(ctx).Errorf("[GORM] "+msg, data...)
+	}
 }
 
 func (l *GormLogger) Trace(
@@ -83,18 +112,34 @@ func (l *GormLogger) Trace(
 	fc func() (string, int64),
 	err error,
 ) {
-	if l.logger.Level >= logrus.DebugLevel {
-		elapsed := time.Since(begin)
+	if l.config.LogLevel <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	threshold := l.config.SlowThreshold
+	switch {
+	case err != nil && l.config.LogLevel >= logger.Error &&
+		!(l.config.IgnoreRecordNotFoundError &&
+			errors.Is(err, gorm.ErrRecordNotFound)):
+		sql, rows := fc()
+		l.logger.WithContext(ctx).WithFields(logrus.Fields{
+			"rows":    rows,
+			"elapsed": elapsed,
+		}).WithError(err).Error("[GORM] " + sql)
+	case threshold != 0 && elapsed > threshold &&
+		l.config.LogLevel >= logger.Warn:
+		sql, rows := fc()
+		l.logger.WithContext(ctx).WithFields(logrus.Fields{
+			"rows":    rows,
+			"elapsed": elapsed,
+		}).Warnf("[GORM] SLOW SQL >= %v: %s", threshold, sql)
+	case l.config.LogLevel >= logger.Info &&
+		l.logger.Level >= logrus.DebugLevel:
 		sql, rows := fc()
-		fields := logrus.Fields{
+		l.logger.WithContext(ctx).WithFields(logrus.Fields{
 			"rows":    rows,
 			"elapsed": elapsed,
-		}
-		if err != nil {
-			l.logger.WithFields(fields).WithError(err).Debug("[GORM] " + sql)
-		} else {
-			l.logger.WithFields(fields).Debug("[GORM] " + sql)
-		}
+		}).Debug("[GORM] " + sql)
 	}
 }
 
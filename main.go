@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	db "people/database"
 	"people/handlers"
 	"people/kafka"
 	"people/logging"
-	"people/models"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/contrib/secure"
 	"github.com/gin-gonic/gin"
@@ -19,7 +27,7 @@ var (
 	log      = logging.Config
 	security = secure.Options{
 		AllowedHosts:          []string{"127.0.0.1:8080", "example.com:443"},
-		SSLRedirect:           false, // true if not behind nginx
+		SSLRedirect:           false, // true if TLS_CERT and TLS_KEY are set
 		SSLHost:               "example.com:443",
 		SSLProxyHeaders:       map[string]string{"X-Forwarded-Proto": "http"},
 		STSSeconds:            315360000,
@@ -32,26 +40,360 @@ var (
 )
 
 func main() {
+	migrateOnly := flag.Bool(
+		"migrate-only", false, "apply database migrations and exit",
+	)
+	flag.Parse()
+	run, err := subcommand(flag.Args())
+	if err != nil {
+		log.Fatal("Subcommand parsing failed: ", err)
+	}
+
+	// GraphQL schema
+	err = handlers.SchemaErr()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Gin mode
+	mode, err := ginMode()
+	if err != nil {
+		log.Fatal("Gin mode parsing failed: ", err)
+	}
+	gin.SetMode(mode)
+	log.Infof("Gin running mode: %v", gin.Mode())
+
+	// Webhooks
+	err = handlers.CheckWebhooks()
+	if err != nil {
+		log.Fatal("Webhooks are misconfigured: ", err)
+	}
+
 	// Connect to database
 	db.Connect()
-	db.C.AutoMigrate(&models.Entry{})
+	err = db.Migrate(db.C, db.Migrations)
+	if err != nil {
+		log.Fatal("Database migration failed: ", err)
+	}
+	if *migrateOnly {
+		log.Info("Migrations applied, exiting")
+		return
+	}
 
 	// Init Redis
 	handlers.InitRedis(os.Getenv("RD_MAIN"))
 
 	// Run Kafka
-	topics := kafka.Topics{
-		{Name: os.Getenv("DATA"), Partitions: 1, Replication: 1},
-		{Name: os.Getenv("FAIL"), Partitions: 1, Replication: 1},
-	}
-	kafka.Start(topics)
-	dataTopic := topics[0]
-	failTopic := topics[1]
-	go handlers.GetMsg(dataTopic, failTopic)
-
-	// Run router
-	r := router()
-	r.Run("127.0.0.1:8080")
+	dataTopics := kafka.Parse(os.Getenv("DATA"), 1, 1)
+	failTopic := kafka.Topic{
+		Name:        os.Getenv("FAIL"),
+		Partitions:  1,
+		Replication: 1,
+	}
+	resultTopic := kafka.Topic{
+		Name:        os.Getenv("RESULT"),
+		Partitions:  1,
+		Replication: 1,
+	}
+	err = kafka.CheckRoles(dataTopics, failTopic)
+	if err == nil {
+		err = kafka.CheckResult(dataTopics, failTopic, resultTopic)
+	}
+	if err != nil {
+		log.Fatal("Kafka topics are misconfigured: ", err)
+	}
+	topics := append(kafka.Topics{failTopic}, dataTopics...)
+	if resultTopic.Name != "" {
+		topics = append(topics, resultTopic)
+	}
+	err = kafka.Start(topics)
+	if err != nil {
+		log.Fatal("Kafka topics setup failed: ", err)
+	}
+	handlers.SetResultTopic(resultTopic)
+
+	// Run purge of the deleted entries
+	go handlers.Purge(make(chan struct{}))
+
+	// Startup summary
+	logStartup(dataTopics, failTopic)
+	log.Infof("Components: API %v, consumer %v", run.api, run.consumer)
+
+	// Run components
+	srv := start(run, starters{
+		consumer: func() {
+			go handlers.GetMsg(dataTopics, failTopic)
+
+			// Run re-enrichment
+			go handlers.Reenrich(make(chan struct{}))
+		},
+		topics: func() {
+			handlers.SetTopics(dataTopics, failTopic)
+		},
+		api: func() *http.Server {
+			security.SSLRedirect = withTLS()
+			srv := &http.Server{
+				Addr:    "127.0.0.1:8080",
+				Handler: router(),
+			}
+			go func() {
+				err := serve(srv)
+				if err != nil && err != http.ErrServerClosed {
+					log.Fatal("Server stopped: ", err)
+				}
+			}()
+			return srv
+		},
+	})
+
+	// Graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Info("Shutting down...")
+	timeout, err := shutdownTimeout()
+	if err != nil {
+		log.Fatal("Shutdown timeout parsing failed: ", err)
+	}
+	err = shutdown(srv, timeout)
+	if err != nil {
+		log.Fatal("Shutdown failed: ", err)
+	}
+	log.Info("Server stopped")
+}
+
+// The function returns the graceful shutdown timeout from the
+// SHUTDOWN_TIMEOUT variable, 10 seconds by default.
+func shutdownTimeout() (time.Duration, error) {
+	value := os.Getenv("SHUTDOWN_TIMEOUT")
+	if value == "" {
+		return 10 * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// The function stops the server, if any, after the requests in progress
+// and waits for the cache writes to reach Redis, otherwise returns an
+// error with its cause.
+func shutdown(srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if srv != nil {
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			return fmt.Errorf("server shutdown failed: %w", err)
+		}
+	}
+	return handlers.FlushCache(timeout)
+}
+
+// The components of the process started by the subcommand.
+type components struct {
+	api      bool
+	consumer bool
+}
+
+// The function returns the components started by the subcommand after
+// the flags: "serve" starts the HTTP API, "consume" starts the Apache
+// Kafka consumer with the re-enrichment and "all", the default, starts
+// both. The unknown or extra arguments return an error.
+func subcommand(args []string) (components, error) {
+	if len(args) > 1 {
+		return components{}, fmt.Errorf("unexpected arguments %q", args[1:])
+	}
+	command := "all"
+	if len(args) == 1 {
+		command = args[0]
+	}
+	switch command {
+	case "serve":
+		return components{api: true}, nil
+	case "consume":
+		return components{consumer: true}, nil
+	case "all":
+		return components{api: true, consumer: true}, nil
+	}
+	return components{}, fmt.Errorf(`unknown subcommand "%s"`, command)
+}
+
+// The functions starting the components of the process.
+type starters struct {
+	consumer func()
+	topics   func()
+	api      func() *http.Server
+}
+
+// The function starts the components selected by the subcommand.
+// Without the consumer the topics are only set for the failures API
+// handlers. Returns the started server, nil without the API.
+func start(run components, with starters) *http.Server {
+	if run.consumer {
+		with.consumer()
+	} else {
+		with.topics()
+	}
+	if !run.api {
+		return nil
+	}
+	return with.api()
+}
+
+// The function logs the single startup summary with the connected
+// dependencies and the effective settings once they are initialized.
+// The secrets are only reported as set or not.
+func logStartup(dataTopics kafka.Topics, failTopic kafka.Topic) {
+	setting := func(name string, def string) string {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+		return def
+	}
+	dbName := os.Getenv("DB_MAIN")
+	if useTest, _ := strconv.ParseBool(os.Getenv("DB_USE_TEST")); useTest {
+		dbName = os.Getenv("DB_TEST")
+	}
+	names := make([]string, len(dataTopics))
+	for i, topic := range dataTopics {
+		names[i] = topic.Name
+	}
+	var webhooks int
+	if value := os.Getenv("WEBHOOK_URLS"); value != "" {
+		webhooks = len(strings.Split(value, ","))
+	}
+	fields := logrus.Fields{
+		"DBName":          dbName,
+		"DBHost":          os.Getenv("DB_HOST") + ":" + os.Getenv("DB_PORT"),
+		"RedisAddr":       os.Getenv("RD_ADDR"),
+		"RedisDB":         os.Getenv("RD_MAIN"),
+		"Brokers":         os.Getenv("AK_ADDR"),
+		"DataTopics":      strings.Join(names, ","),
+		"FailTopic":       failTopic.Name,
+		"ResultTopic":     os.Getenv("RESULT"),
+		"ReenrichWorkers": setting("REENRICH_WORKERS", "3"),
+		"BatchWorkers":    setting("ENRICH_BATCH_WORKERS", "3"),
+		"Mode":            gin.Mode(),
+		"ReadOnly":        setting("READ_ONLY", "false"),
+		"DuplicateMode":   setting("DUPLICATE_MODE", "insert"),
+		"IDType":          setting("ID_TYPE", "int"),
+		"EnrichMode":      setting("ENRICH_MODE", "parallel"),
+		"CacheCompress":   setting("CACHE_COMPRESS", "none"),
+		"StrictJSON":      setting("STRICT_JSON", "false"),
+		"TLS":             withTLS(),
+		"Webhooks":        webhooks,
+	}
+	for _, name := range handlers.Secrets {
+		fields[name] = "unset"
+		if os.Getenv(name) != "" {
+			fields[name] = "[REDACTED]"
+		}
+	}
+	log.WithFields(fields).Info("Startup summary")
+}
+
+// The function returns the Gin running mode from the GIN_MODE variable,
+// otherwise derives it from the APP_ENV variable. The debug mode is used
+// by default, the unknown values return an error.
+func ginMode() (string, error) {
+	if mode := os.Getenv("GIN_MODE"); mode != "" {
+		switch mode {
+		case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+			return mode, nil
+		}
+		return "", fmt.Errorf(`unknown GIN_MODE "%s"`, mode)
+	}
+	switch env := os.Getenv("APP_ENV"); env {
+	case "", "development":
+		return gin.DebugMode, nil
+	case "production":
+		return gin.ReleaseMode, nil
+	case "test":
+		return gin.TestMode, nil
+	default:
+		return "", fmt.Errorf(`unknown APP_ENV "%s"`, env)
+	}
+}
+
+// The browser-oriented security headers toggled by the environment
+// variables with the setting disabling each of them.
+var securityHeaders = []struct {
+	env     string
+	disable func(options *secure.Options)
+}{
+	{
+		env:     "SECURE_FRAME_DENY",
+		disable: func(o *secure.Options) { o.FrameDeny = false },
+	},
+	{
+		env:     "SECURE_STS",
+		disable: func(o *secure.Options) { o.STSSeconds = 0 },
+	},
+	{
+		env:     "SECURE_NOSNIFF",
+		disable: func(o *secure.Options) { o.ContentTypeNosniff = false },
+	},
+	{
+		env:     "SECURE_XSS_FILTER",
+		disable: func(o *secure.Options) { o.BrowserXssFilter = false },
+	},
+	{
+		env:     "SECURE_CSP",
+		disable: func(o *secure.Options) { o.ContentSecurityPolicy = "" },
+	},
+}
+
+// The function returns the security options with the headers disabled
+// by their "false" variables, so the API-only deployments behind a
+// gateway can drop them. Empty variables keep the headers.
+func securityOptions() secure.Options {
+	options := security
+	for _, header := range securityHeaders {
+		value := os.Getenv(header.env)
+		if value == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("Failed to parse %s flag: %v", header.env, err)
+		}
+		if !enabled {
+			header.disable(&options)
+		}
+	}
+	return options
+}
+
+// The function returns the routing flag from the environment variable,
+// the default if it is empty.
+func routeFlag(env string, def bool) bool {
+	value := os.Getenv(env)
+	if value == "" {
+		return def
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Failed to parse %s flag: %v", env, err)
+	}
+	return enabled
+}
+
+// The function reports whether the TLS certificate and key are configured
+// to terminate HTTPS in the server without a reverse proxy.
+func withTLS() bool {
+	return os.Getenv("TLS_CERT") != "" && os.Getenv("TLS_KEY") != ""
+}
+
+// The function starts the server via HTTPS if the TLS certificate and key
+// are configured, otherwise via plain HTTP.
+func serve(srv *http.Server) error {
+	if withTLS() {
+		log.Info("Serving HTTPS on ", srv.Addr)
+		return srv.ListenAndServeTLS(
+			os.Getenv("TLS_CERT"),
+			os.Getenv("TLS_KEY"),
+		)
+	}
+	log.Info("Serving HTTP on ", srv.Addr)
+	return srv.ListenAndServe()
 }
 
 func router() *gin.Engine {
@@ -60,14 +402,81 @@ func router() *gin.Engine {
 	r.SetTrustedProxies([]string{"127.0.0.1"})
 	r.Use(gin.LoggerWithWriter(log.WriterLevel(logrus.InfoLevel)))
 	r.Use(gin.RecoveryWithWriter(log.WriterLevel(logrus.ErrorLevel)))
-	r.Use(secure.Secure(security))
+	r.Use(secure.Secure(securityOptions()))
+	r.Use(handlers.Shed(
+		"/health",
+		"/api/events",
+		"/cache/metrics",
+		"/cache/keys",
+		"/enrich/quota",
+		"/enrich/failures",
+		"/kafka/throughput",
+		"/debug/inflight",
+	))
+	r.Use(handlers.Timeout(map[string]time.Duration{
+		"/api/events":       0,
+		"/api/import":       10 * time.Minute,
+		"/api/export.jsonl": 10 * time.Minute,
+		"/api/import.jsonl": 10 * time.Minute,
+	}))
 
 	// Routes
 	api := r.Group("/api")
-	api.POST("/create", handlers.Create)
+	api.POST("/create", handlers.NoStore, handlers.Writable, handlers.Create)
 	api.GET("/read", handlers.Read)
-	api.PATCH("/update", handlers.Update)
-	api.DELETE("/delete", handlers.Delete)
-	r.POST("/graphql", handlers.GraphQL)
+	api.GET("/read/:id/provenance", handlers.Provenance)
+	api.GET("/diff", handlers.Diff)
+	api.GET("/enrich", handlers.EnrichPreview)
+	api.POST("/enrich/batch", handlers.NoStore, handlers.EnrichBatch)
+	api.POST("/validate/batch", handlers.NoStore, handlers.ValidateBatch)
+	api.PATCH("/update", handlers.NoStore, handlers.Writable, handlers.Update)
+	api.DELETE("/delete", handlers.NoStore, handlers.Writable, handlers.Delete)
+	api.POST("/merge", handlers.NoStore, handlers.Writable, handlers.Merge)
+	api.POST("/import", handlers.NoStore, handlers.Writable, handlers.Import)
+	api.GET("/export.jsonl", handlers.NoStore, handlers.ExportJSONL)
+	api.POST(
+		"/import.jsonl",
+		handlers.NoStore,
+		handlers.Writable,
+		handlers.ImportJSONL,
+	)
+	api.POST(
+		"/failures/requeue",
+		handlers.NoStore,
+		handlers.Writable,
+		handlers.Requeue,
+	)
+	api.GET("/failures/peek", handlers.NoStore, handlers.PeekFailures)
+	api.POST("/failures/consume", handlers.NoStore, handlers.ConsumeFailures)
+	api.GET("/events", handlers.Events)
+	api.GET("/meta/fields", handlers.Fields)
+	api.GET("/config", handlers.NoStore, handlers.Config)
+	api.PUT("/admin/read-only", handlers.NoStore, handlers.SetReadOnly)
+	api.POST("/admin/migrate", handlers.NoStore, handlers.Migrate)
+	api.GET("/admin/schema", handlers.NoStore, handlers.SchemaStatus)
+	api.POST(
+		"/admin/purge",
+		handlers.NoStore,
+		handlers.Writable,
+		handlers.PurgeDeleted,
+	)
+	r.POST("/graphql", handlers.NoStore, handlers.GraphQL)
+	r.GET("/cache/metrics", handlers.NoStore, handlers.CacheMetrics)
+	r.GET("/cache/keys", handlers.NoStore, handlers.CacheKeys)
+	r.GET("/enrich/quota", handlers.NoStore, handlers.EnrichQuota)
+	r.GET("/enrich/failures", handlers.NoStore, handlers.EnrichFailures)
+	r.GET("/kafka/throughput", handlers.NoStore, handlers.Throughput)
+	r.GET("/debug/inflight", handlers.NoStore, handlers.Inflight)
+	r.GET("/health", handlers.NoStore, handlers.Health)
+	r.HandleMethodNotAllowed = true
+	// The trailing slash and the case of the path are fixed by redirects:
+	// 301 for GET and 307 for other methods, which keeps the method and
+	// the body. Clients must not follow the 307 of a POST to other hosts,
+	// and some of them don't follow it at all, so the writers should use
+	// the canonical paths.
+	r.RedirectTrailingSlash = routeFlag("ROUTE_TRAILING_SLASH", true)
+	r.RedirectFixedPath = routeFlag("ROUTE_CASE_INSENSITIVE", false)
+	r.NoRoute(handlers.NoRoute)
+	r.NoMethod(handlers.NoMethod)
 	return r
 }
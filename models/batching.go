@@ -0,0 +1,237 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The maximum number of names in a single batch request of the
+// providers.
+const providerBatchMax = 10
+
+// The result of the provider request delivered to the waiting name.
+type providerResult struct {
+	data map[string]interface{}
+	err  error
+}
+
+// The names collected within the batch window with their waiters.
+type providerBatch struct {
+	names   []string
+	waiters map[string][]chan providerResult
+}
+
+// The batcher coalesces the concurrent requests of one provider into
+// the batch requests by the name[] query.
+type batcher struct {
+	mu      sync.Mutex
+	base    string
+	country string
+	field   string
+	current *providerBatch
+}
+
+var batchers = struct {
+	mu    sync.Mutex
+	items map[string]*batcher
+}{items: make(map[string]*batcher)}
+
+// The function returns the batcher of the provider by its base url and
+// the country hint.
+func batcherOf(base string, country string, field string) *batcher {
+	batchers.mu.Lock()
+	defer batchers.mu.Unlock()
+	key := base + "?" + country
+	b, ok := batchers.items[key]
+	if !ok {
+		b = &batcher{base: base, country: country, field: field}
+		batchers.items[key] = b
+	}
+	return b
+}
+
+// The function of the provider request by the name and the country
+// hint. With the positive ENRICH_BATCH_WINDOW the uncached names are
+// coalesced within the window into the batch requests of up to 10
+// names, otherwise the single request is sent. The response of the
+// single provider shared by the context is used instead, if any. Fills
+// out data map like apiReq(), otherwise returns an error.
+func providerReq(
+	ctx context.Context,
+	base string,
+	name string,
+	country string,
+	field string,
+	reqData *map[string]interface{},
+) error {
+	if shared, ok := ctx.Value(combinedKey{}).(combined); ok {
+		*reqData = shared.data
+		return shared.err
+	}
+	url := providerURL(base, name, country)
+	window := duration("ENRICH_BATCH_WINDOW", 0)
+	if window <= 0 {
+		return apiReq(ctx, url, field, reqData)
+	}
+	if data, ok := cache.get(url); ok {
+		*reqData = data
+		return nil
+	}
+	done := batcherOf(base, country, field).add(name, window)
+	select {
+	case result := <-done:
+		if result.err != nil {
+			return result.err
+		}
+		*reqData = result.data
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The method adds the name to the current batch and returns the channel
+// of its result. The batch is sent when the window expires or it is
+// full.
+func (b *batcher) add(name string, window time.Duration) chan providerResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	done := make(chan providerResult, 1)
+	if b.current == nil {
+		batch := &providerBatch{
+			waiters: make(map[string][]chan providerResult),
+		}
+		b.current = batch
+		time.AfterFunc(window, func() {
+			b.mu.Lock()
+			if b.current != batch {
+				b.mu.Unlock()
+				return
+			}
+			b.current = nil
+			b.mu.Unlock()
+			b.send(batch)
+		})
+	}
+	batch := b.current
+	if _, ok := batch.waiters[name]; !ok {
+		batch.names = append(batch.names, name)
+	}
+	batch.waiters[name] = append(batch.waiters[name], done)
+	if len(batch.names) >= providerBatchMax {
+		b.current = nil
+		go b.send(batch)
+	}
+	return done
+}
+
+// The method requests the provider data of the batch names and delivers
+// the results to their waiters. The single name falls back to the
+// single request as the batch does not save anything. The requests are
+// bounded by the batchTimeout, as the batch outlives the contexts of
+// its waiters.
+func (b *batcher) send(batch *providerBatch) {
+	timeout := batchTimeout
+	if timeout <= 0 {
+		timeout = enrichClient.Timeout
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	results := make(map[string]providerResult, len(batch.names))
+	if len(batch.names) == 1 {
+		name := batch.names[0]
+		var data map[string]interface{}
+		err := apiReq(
+			ctx,
+			providerURL(b.base, name, b.country),
+			b.field,
+			&data,
+		)
+		results[name] = providerResult{data: data, err: err}
+	} else {
+		list, err := b.request(ctx, batch.names)
+		for i, name := range batch.names {
+			if err != nil {
+				results[name] = providerResult{err: err}
+				continue
+			}
+			results[name] = providerResult{data: list[i]}
+		}
+	}
+	for name, waiters := range batch.waiters {
+		for _, done := range waiters {
+			done <- results[name]
+		}
+	}
+}
+
+// The method sends the batch request of the names to the provider and
+// caches the response data of every name. The failed response leaves
+// the data of every name empty and uncached like apiReq(). Returns the
+// data in the order of the names, otherwise an error.
+func (b *batcher) request(
+	ctx context.Context,
+	names []string,
+) ([]map[string]interface{}, error) {
+	query := make([]string, len(names))
+	for i, name := range names {
+		query[i] = "name[]=" + url.QueryEscape(name)
+	}
+	if b.country != "" {
+		query = append(query, "country_id="+url.QueryEscape(b.country))
+	}
+	link := b.base + "?" + strings.Join(query, "&")
+	if err := throttle(ctx, link); err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := enrichClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(response.Body)
+	trackQuota(link, response.Header)
+	if response.StatusCode != http.StatusOK {
+		log.Debugf(
+			"batch request to %s failed with status %d",
+			b.base,
+			response.StatusCode,
+		)
+		list := make([]map[string]interface{}, len(names))
+		for i := range list {
+			list[i] = map[string]interface{}{}
+		}
+		return list, nil
+	}
+	var list []map[string]interface{}
+	err = json.NewDecoder(response.Body).Decode(&list)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) != len(names) {
+		return nil, fmt.Errorf(
+			"batch response of %s has %d items for %d names",
+			b.base,
+			len(list),
+			len(names),
+		)
+	}
+	for i, name := range names {
+		key := providerURL(b.base, name, b.country)
+		cache.set(key, list[i], cacheTTL(list[i], b.field))
+	}
+	return list, nil
+}
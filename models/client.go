@@ -0,0 +1,43 @@
+package models
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// The HTTP client of the enrichment providers shared by all requests.
+var enrichClient = newEnrichClient()
+
+// The deadline of the batch provider requests not bound by the context
+// of a single message, ENRICH_TIMEOUT or the ENRICH_HTTP_TIMEOUT of the
+// client if disabled.
+var batchTimeout = duration("ENRICH_TIMEOUT", 0)
+
+// The function creates the HTTP client pooling the connections to the
+// enrichment providers. ENRICH_MAX_IDLE_CONNS and its per host value
+// ENRICH_MAX_IDLE_PER_HOST limit the idle connections kept for reuse,
+// ENRICH_MAX_CONNS_PER_HOST limits all connections to the provider, 0
+// means no limit, and ENRICH_IDLE_TIMEOUT closes the unused ones. The
+// ENRICH_KEEP_ALIVE set to false disables the reuse. Every request is
+// bounded by the ENRICH_HTTP_TIMEOUT.
+func newEnrichClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = integer("ENRICH_MAX_IDLE_CONNS", 100)
+	transport.MaxIdleConnsPerHost = integer("ENRICH_MAX_IDLE_PER_HOST", 32)
+	transport.MaxConnsPerHost = integer("ENRICH_MAX_CONNS_PER_HOST", 0)
+	transport.IdleConnTimeout = duration("ENRICH_IDLE_TIMEOUT", 90*time.Second)
+	transport.DisableKeepAlives = os.Getenv("ENRICH_KEEP_ALIVE") == "false"
+	return &http.Client{
+		Transport: transport,
+		Timeout:   duration("ENRICH_HTTP_TIMEOUT", 10*time.Second),
+	}
+}
+
+// The function reads the rest of the response body and closes it, so
+// that the connection is returned to the pool for reuse.
+func closeBody(body io.ReadCloser) {
+	io.Copy(io.Discard, body)
+	body.Close()
+}
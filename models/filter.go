@@ -0,0 +1,181 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// The maximum number of the nodes of the structured filter.
+const filterNodesMax = 64
+
+// The SQL comparisons of the filter operators.
+var filterOps = map[string]string{
+	"eq":  "=",
+	"ne":  "<>",
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
+}
+
+// The model of the structured filter of the entries. The node is either
+// the "and" or the "or" group of the nested filters, or the condition
+// of the whitelisted field with the operator and the value.
+type Filter struct {
+	And   []Filter    `json:"and,omitempty"`
+	Or    []Filter    `json:"or,omitempty"`
+	Field string      `json:"field,omitempty"`
+	Op    string      `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// The function decodes the structured filter from its JSON, as is or
+// encoded in base64, and checks it against the column whitelist,
+// otherwise returns an error.
+func ParseFilter(param string) (*Filter, error) {
+	data := []byte(strings.TrimSpace(param))
+	if !bytes.HasPrefix(data, []byte("{")) {
+		encoded := strings.TrimRight(string(data), "=")
+		decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			decoded, err = base64.RawStdEncoding.DecodeString(encoded)
+		}
+		if err != nil {
+			return nil, errors.New("filter is neither JSON nor base64")
+		}
+		data = decoded
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var filter Filter
+	if err := decoder.Decode(&filter); err != nil {
+		return nil, fmt.Errorf("malformed filter: %v", err)
+	}
+	nodes := 0
+	if err := filter.check(&nodes); err != nil {
+		return nil, err
+	}
+	return &filter, nil
+}
+
+// The method checks the node and its nested filters, counting them
+// against the maximum number of the nodes.
+func (f Filter) check(nodes *int) error {
+	*nodes++
+	if *nodes > filterNodesMax {
+		return fmt.Errorf("filter exceeds %d nodes", filterNodesMax)
+	}
+	kinds := 0
+	for _, set := range []bool{f.And != nil, f.Or != nil, f.Field != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return errors.New(`filter node needs one of "and", "or", "field"`)
+	}
+	for _, group := range [][]Filter{f.And, f.Or} {
+		if group != nil && len(group) == 0 {
+			return errors.New("filter group is empty")
+		}
+		for _, nested := range group {
+			if err := nested.check(nodes); err != nil {
+				return err
+			}
+		}
+	}
+	if f.Field == "" {
+		return nil
+	}
+	_, _, err := f.condition()
+	return err
+}
+
+// The method returns the filtering condition of the field node with its
+// argument, otherwise returns an error for the field, the operator or
+// the value not allowed by the column whitelist.
+func (f Filter) condition() (string, interface{}, error) {
+	column, ok := FilterColumn(f.Field)
+	if !ok {
+		return "", nil, fmt.Errorf(`column "%s" is not filterable`, f.Field)
+	}
+	allowed := false
+	for _, op := range column.Operators {
+		if op == f.Op {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return "", nil, fmt.Errorf(
+			`operator "%s" is not allowed for column "%s"`,
+			f.Op,
+			column.Name,
+		)
+	}
+	var value interface{}
+	switch v := f.Value.(type) {
+	case string:
+		if column.Type == "string" {
+			value = v
+		}
+	case float64:
+		if column.Type == "integer" && v == math.Trunc(v) {
+			value = int(v)
+		}
+	}
+	if value == nil {
+		return "", nil, fmt.Errorf(
+			`column "%s" expects %s value`,
+			column.Name,
+			article(column.Type),
+		)
+	}
+	if f.Op == "like" {
+		return column.Name + " LIKE ?", fmt.Sprintf("%%%v%%", value), nil
+	}
+	return column.Name + " " + filterOps[f.Op] + " ?", value, nil
+}
+
+// The method returns the SQL condition of the checked filter with its
+// arguments, the groups are parenthesized.
+func (f Filter) Where() (string, []interface{}) {
+	if f.Field != "" {
+		clause, arg, _ := f.condition()
+		return clause, []interface{}{arg}
+	}
+	group, join := f.And, " AND "
+	if f.Or != nil {
+		group, join = f.Or, " OR "
+	}
+	clauses := make([]string, 0, len(group))
+	var args []interface{}
+	for _, nested := range group {
+		clause, nestedArgs := nested.Where()
+		clauses = append(clauses, clause)
+		args = append(args, nestedArgs...)
+	}
+	return "(" + strings.Join(clauses, join) + ")", args
+}
+
+// The method returns the canonical JSON of the filter for the cache
+// key, the empty string for no filter.
+func (f *Filter) Key() string {
+	if f == nil {
+		return ""
+	}
+	data, _ := json.Marshal(f)
+	return string(data)
+}
+
+// The function returns the type name with its indefinite article.
+func article(name string) string {
+	if strings.ContainsRune("aeiou", rune(name[0])) {
+		return "an " + name
+	}
+	return "a " + name
+}
@@ -1,53 +1,163 @@
 package models
 
 import (
+	"container/list"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"os"
 	"people/logging"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 
+	"github.com/google/uuid"
+	_ "github.com/joho/godotenv/autoload"
 	"gorm.io/gorm"
 )
 
-var log = logging.Config
+var (
+	log            = logging.Config
+	AgifyURL       = "https://api.agify.io/"
+	GenderizeURL   = "https://api.genderize.io/"
+	NationalizeURL = "https://api.nationalize.io/"
+	enrichTTL      = duration("ENRICH_TTL", time.Hour)
+	enrichNegTTL   = duration("ENRICH_NEG_TTL", 5*time.Minute)
+	enrichNameMax  = integer("ENRICH_NAME_MAX", 50)
+	enrichAgeMin   = integer("ENRICH_AGE_MIN", 1)
+	enrichAgeMax   = integer("ENRICH_AGE_MAX", 120)
+	enrichNations  = positive("ENRICH_NATIONALITY_MAX", 5)
+	MinConfidence  = fraction("ENRICH_MIN_CONFIDENCE", 0)
+	cache          = newEnrichCache(positive("ENRICH_CACHE_MAX", 10000))
+	namePattern    = regexp.MustCompile(`^[a-zA-Zа-яА-Я]+$`)
+	countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// The function parses the duration from the environment variable,
+// otherwise returns the default value.
+func duration(env string, def time.Duration) time.Duration {
+	value := os.Getenv(env)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Failed to parse %s duration: %v", env, err)
+	}
+	return d
+}
+
+// The function parses the integer from the environment variable,
+// otherwise returns the default value.
+func integer(env string, def int) int {
+	value := os.Getenv(env)
+	if value == "" {
+		return def
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Failed to parse %s integer: %v", env, err)
+	}
+	return i
+}
+
+// The function parses the positive integer from the environment
+// variable, otherwise returns the default value.
+func positive(env string, def int) int {
+	i := integer(env, def)
+	if i < 1 {
+		log.Fatalf("Failed to parse %s integer: %d is not positive", env, i)
+	}
+	return i
+}
+
+// The function parses the fraction of 0-1 from the environment
+// variable, otherwise returns the default value.
+func fraction(env string, def float64) float64 {
+	value := os.Getenv(env)
+	if value == "" {
+		return def
+	}
+	x, err := strconv.ParseFloat(value, 64)
+	if err == nil && (x < 0 || x > 1) {
+		err = errors.New("out of 0-1")
+	}
+	if err != nil {
+		log.Fatalf("Failed to parse %s fraction: %v", env, err)
+	}
+	return x
+}
+
+// Machine-readable codes of the handler errors.
+const (
+	CodeBadRequest       = "BAD_REQUEST"
+	CodeValidationFailed = "VALIDATION_FAILED"
+	CodeNotFound         = "NOT_FOUND"
+	CodeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	CodeConflict         = "CONFLICT"
+	CodeUnauthorized     = "UNAUTHORIZED"
+	CodeForbidden        = "FORBIDDEN"
+	CodeReadOnly         = "READ_ONLY"
+	CodeTooLarge         = "PAYLOAD_TOO_LARGE"
+	CodeProviderFailed   = "PROVIDER_FAILED"
+	CodeTimeout          = "TIMEOUT"
+	CodeOverloaded       = "OVERLOADED"
+	CodeInternal         = "INTERNAL"
+)
 
-// The model for parsing data from the Apache Kafka messages.
+// The error of the write attempt in the read-only mode.
+var ErrReadOnly = errors.New("read-only mode")
+
+// The model of the error envelope returned by the handlers.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details string `json:"details,omitempty"`
+}
+
+// The model for parsing data from the Apache Kafka messages. The age,
+// gender and nationality are optional and known by the source system.
 type FullName struct {
-	Name       string
-	Surname    string
-	Patronymic string
-	Error      string
+	Name        string
+	Surname     string
+	Patronymic  string
+	Age         uint8  `json:",omitempty"`
+	Gender      string `json:",omitempty"`
+	Nationality string `json:",omitempty"`
+	Source      string
+	Error       string
+	Status      EnrichStatus `json:",omitempty"`
 }
 
 // The method of the data validity checking in the FullName model.
 func (e *FullName) IsValid() string {
-	namePattern := `^[a-zA-Zа-яА-Я]+$`
 	var errContent []string
-	// Name
-	switch {
-	case e.Name == "":
-		errContent = append(errContent, "name cannot be empty")
-	case len(e.Name) < 2:
-		errContent = append(errContent, "name is too short")
-	case len(e.Name) > 50:
-		errContent = append(errContent, "name is too long")
-	case !regexp.MustCompile(namePattern).MatchString(e.Name):
-		errContent = append(errContent, "name contains invalid characters")
-	}
-	// Surname
-	switch {
-	case e.Surname == "":
-		errContent = append(errContent, "surname cannot be empty")
-	case len(e.Surname) < 2:
-		errContent = append(errContent, "surname is too short")
-	case len(e.Surname) > 50:
-		errContent = append(errContent, "surname is too long")
-	case !regexp.MustCompile(namePattern).MatchString(e.Surname):
-		errContent = append(errContent, "surname contains invalid characters")
+	causes := []string{
+		checkName("name", e.Name),
+		checkName("surname", e.Surname),
+	}
+	if e.Age != 0 {
+		causes = append(causes, checkAge(int(e.Age)))
+	}
+	if e.Gender != "" {
+		causes = append(causes, checkGender(e.Gender))
+	}
+	if e.Nationality != "" {
+		causes = append(causes, checkNationality(e.Nationality))
+	}
+	for _, cause := range causes {
+		if cause != "" {
+			errContent = append(errContent, cause)
+		}
 	}
 	if len(errContent) == 0 {
 		return ""
@@ -56,6 +166,22 @@ func (e *FullName) IsValid() string {
 	return err
 }
 
+// Statuses of the message processing events.
+const (
+	EventCreated = "created"
+	EventFailed  = "failed"
+)
+
+// The model of the message processing outcome for the event stream.
+type Event struct {
+	Status  string `json:"status"`
+	Source  string `json:"source"`
+	ID      uint   `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Surname string `json:"surname"`
+	Reason  string `json:"reason,omitempty"`
+}
+
 // The model for parsing data into GraphQL answers.
 type GraphQL struct {
 	ID          uint
@@ -67,167 +193,1125 @@ type GraphQL struct {
 	Nationality string
 }
 
-// The model for saving data in the database.
+// The model for saving data in the database. The gorm.Model fields are
+// declared explicitly, so the JSON has a single "ID" key and the
+// DeletedAt field keeps the soft delete.
 type Entry struct {
-	gorm.Model
-	ID          uint   `gorm:"primarykey"`
-	Name        string `gorm:"not null"`
-	Surname     string `gorm:"not null"`
-	Patronymic  string `gorm:"default:''"`
-	Age         uint8  `gorm:"not null"`
-	Gender      string `gorm:"not null"`
-	Nationality string `gorm:"not null"`
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	Name        string         `gorm:"not null"`
+	Surname     string         `gorm:"not null"`
+	Patronymic  string         `gorm:"default:''"`
+	Age         uint8          `gorm:"not null"`
+	Gender      string         `gorm:"not null"`
+	Nationality string         `gorm:"not null"`
+	Source      string         `gorm:"default:''"`
+	Verified    bool           `gorm:"not null;default:false"`
+	UUID        *string        `gorm:"type:uuid;uniqueIndex" json:",omitempty"`
+	Provenance  []Provenance   `gorm:"foreignKey:EntryID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// The method encodes the Entry model to JSON. The integer ID is hidden
+// in the UUID mode, so the clients see the UUID as the only identifier.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	if IDType() != IDUUID {
+		return json.Marshal(entry(e))
+	}
+	return json.Marshal(struct {
+		ID *uint `json:",omitempty"`
+		entry
+	}{entry: entry(e)})
+}
+
+// The Entry model encoded to JSON with the integer ID in any mode for
+// the cache and the backup.
+type StoredEntry Entry
+
+// The modes of the public identifier of the Entry model.
+const (
+	IDInt  = "int"
+	IDUUID = "uuid"
+)
+
+// The function returns the public identifier mode from the ID_TYPE
+// environment variable. The integer ID is used by default.
+func IDType() string {
+	if os.Getenv("ID_TYPE") == IDUUID {
+		return IDUUID
+	}
+	return IDInt
+}
+
+// The hook assigns the random UUID to the new Entry in the UUID mode.
+func (e *Entry) BeforeCreate(tx *gorm.DB) error {
+	if IDType() == IDUUID && e.UUID == nil {
+		id := uuid.NewString()
+		e.UUID = &id
+	}
+	return nil
+}
+
+// The method returns the public identifier of the Entry in the current
+// mode.
+func (e *Entry) PublicID() string {
+	if IDType() == IDUUID {
+		if e.UUID == nil {
+			return ""
+		}
+		return *e.UUID
+	}
+	return strconv.FormatUint(uint64(e.ID), 10)
+}
+
+// The function returns the query condition of the entries by the list
+// of the public identifiers in the current mode, each one checked by
+// EntryCond(), otherwise returns an error.
+func EntriesCond(ids []string) (string, interface{}, error) {
+	var ints []uint
+	var uuids []string
+	for _, id := range ids {
+		_, arg, err := EntryCond(id)
+		if err != nil {
+			return "", nil, err
+		}
+		switch v := arg.(type) {
+		case uint:
+			ints = append(ints, v)
+		case string:
+			uuids = append(uuids, v)
+		}
+	}
+	if IDType() == IDUUID {
+		return "uuid IN ?", uuids, nil
+	}
+	return "id IN ?", ints, nil
+}
+
+// The function returns the query condition of the Entry by the public
+// identifier in the current mode, otherwise returns an error.
+func EntryCond(id string) (string, interface{}, error) {
+	if IDType() == IDUUID {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			return "", nil, fmt.Errorf(`invalid entry UUID "%s"`, id)
+		}
+		return "uuid = ?", parsed.String(), nil
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || n == 0 {
+		return "", nil, fmt.Errorf(`invalid entry ID "%s"`, id)
+	}
+	return "id = ?", uint(n), nil
+}
+
+// The model for saving the origin of the enriched fields of an Entry.
+type Provenance struct {
+	ID          uint        `gorm:"primarykey"`
+	EntryID     uint        `gorm:"index;not null"`
+	Field       string      `gorm:"not null"`
+	Provider    string      `gorm:"not null"`
+	Value       string      `gorm:"not null"`
+	Probability float64     `gorm:"default:0"`
+	Count       int         `gorm:"default:0"`
+	FetchedAt   time.Time   `gorm:"not null"`
+	Candidates  []Candidate `gorm:"serializer:json" json:",omitempty"`
+}
+
+// The model of the country proposed by the nationality provider with
+// its probability.
+type Candidate struct {
+	CountryID   string  `json:"country_id"`
+	Probability float64 `json:"probability"`
+}
+
+// The model of the audit record of the entry merged into the kept one
+// with the JSON object of the merged fields and their source entries.
+type Merge struct {
+	ID        uint      `gorm:"primarykey"`
+	KeptID    uint      `gorm:"index;not null"`
+	RemovedID uint      `gorm:"index;not null"`
+	Fields    string    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// The function normalizes the text value received from the clients by
+// trimming the surrounding whitespace.
+func normalize(value string) string {
+	return strings.TrimSpace(value)
+}
+
+// The function trims and uppercases the nationality code, so the
+// lowercase code passes the validity checking.
+func normalizeCountry(value string) string {
+	return strings.ToUpper(normalize(value))
+}
+
+// The method normalizes the text fields of the Entry model before the
+// validity checking.
+func (e *Entry) Normalize() {
+	e.Name = normalize(e.Name)
+	e.Surname = normalize(e.Surname)
+	e.Patronymic = normalize(e.Patronymic)
+	e.Gender = normalize(e.Gender)
+	e.Nationality = normalizeCountry(e.Nationality)
+}
+
+// The method normalizes the text fields of the FullName model before
+// the validity checking.
+func (e *FullName) Normalize() {
+	e.Name = normalize(e.Name)
+	e.Surname = normalize(e.Surname)
+	e.Patronymic = normalize(e.Patronymic)
+	e.Gender = normalize(e.Gender)
+	e.Nationality = normalizeCountry(e.Nationality)
 }
 
 // The method of the data validity checking in the Entry model.
 func (e *Entry) IsValid() error {
-	namePattern := `^[a-zA-Zа-яА-Я]+$`
-	countryPattern := `^[A-Z]{2}$`
+	errContent := e.Causes()
+	if len(errContent) == 0 {
+		return nil
+	}
+	err := strings.Join(errContent, ", ")
+	return errors.New(err)
+}
+
+// The method returns the causes of the Entry model invalidity by the
+// fields, otherwise returns nil.
+func (e *Entry) Causes() []string {
 	var errContent []string
-	// Name
-	switch {
-	case e.Name == "":
-		errContent = append(errContent, "name cannot be empty")
-	case len(e.Name) < 2:
-		errContent = append(errContent, "name is too short")
-	case len(e.Name) > 50:
-		errContent = append(errContent, "name is too long")
-	case !regexp.MustCompile(namePattern).MatchString(e.Name):
-		errContent = append(errContent, "name contains invalid characters")
-	}
-	// Surname
-	switch {
-	case e.Surname == "":
-		errContent = append(errContent, "surname cannot be empty")
-	case len(e.Surname) < 2:
-		errContent = append(errContent, "surname is too short")
-	case len(e.Surname) > 50:
-		errContent = append(errContent, "surname is too long")
-	case !regexp.MustCompile(namePattern).MatchString(e.Surname):
-		errContent = append(errContent, "surname contains invalid characters")
-	}
-	// Age
-	if e.Age < 1 || e.Age > 120 {
-		errContent = append(errContent, "age contains invalid data")
-	}
-	// Gender
+	for _, cause := range []string{
+		checkName("name", e.Name),
+		checkName("surname", e.Surname),
+		checkAge(int(e.Age)),
+		checkGender(e.Gender),
+		checkNationality(e.Nationality),
+	} {
+		if cause != "" {
+			errContent = append(errContent, cause)
+		}
+	}
+	return errContent
+}
+
+// The age from which the valid value is reported as suspicious.
+const warnAge = 100
+
+// The method of the suspicious data checking in the valid Entry model.
+// Returns the non-fatal warnings which do not block the saving.
+func (e *Entry) Warnings() []string {
+	var warnings []string
+	if int(e.Age) >= warnAge {
+		warnings = append(warnings, "age is unusually high")
+	}
+	for _, field := range []struct{ name, value string }{
+		{"name", e.Name},
+		{"surname", e.Surname},
+		{"patronymic", e.Patronymic},
+	} {
+		if repeated(field.value) {
+			warnings = append(
+				warnings,
+				field.name+" consists of a single repeated letter",
+			)
+		}
+	}
+	return warnings
+}
+
+// The function reports whether the value consists of one letter
+// repeated regardless of the case.
+func repeated(value string) bool {
+	runes := []rune(strings.ToLower(value))
+	if len(runes) < 2 {
+		return false
+	}
+	for _, r := range runes[1:] {
+		if r != runes[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// The errors of the age coercion from the JSON and GraphQL numbers.
+var (
+	ErrAgeNotInteger = errors.New("age must be an integer")
+	ErrAgeRange      = errors.New("age contains invalid data")
+)
+
+// The error of the provider result out of the plausibility bounds.
+var ErrImplausible = errors.New("implausible enrichment")
+
+// The function coerces the decoded number to the age. Integral floats
+// are accepted, fractional values and values out of the uint8 range
+// return an error.
+func ParseAge(value float64) (uint8, error) {
+	if value != math.Trunc(value) {
+		return 0, ErrAgeNotInteger
+	}
+	if value < 0 || value > math.MaxUint8 {
+		return 0, ErrAgeRange
+	}
+	return uint8(value), nil
+}
+
+// The error of the JSON field unknown to the Entry model in the strict
+// decoding.
+var ErrUnknownField = errors.New("unknown field")
+
+// The method decodes the Entry model from JSON with the robust age
+// coercion, otherwise returns an error.
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	return e.decode(func(v interface{}) error {
+		return json.Unmarshal(data, v)
+	})
+}
+
+// The method decodes the Entry model like UnmarshalJSON() rejecting the
+// unknown fields with ErrUnknownField, otherwise returns an error.
+func (e *Entry) DecodeStrict(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	err := e.decode(decoder.Decode)
+	if err == nil {
+		return nil
+	}
+	field, ok := strings.CutPrefix(err.Error(), "json: unknown field ")
+	if ok {
+		return fmt.Errorf("%w %s", ErrUnknownField, field)
+	}
+	return err
+}
+
+// The method decodes the Entry model by the decoding function with the
+// age coerced separately.
+func (e *Entry) decode(decode func(v interface{}) error) error {
+	type entry Entry
+	aux := struct {
+		*entry
+		Age json.RawMessage
+	}{entry: (*entry)(e)}
+	err := decode(&aux)
+	if err != nil {
+		return err
+	}
+	if len(aux.Age) == 0 || string(aux.Age) == "null" {
+		return nil
+	}
+	var value float64
+	err = json.Unmarshal(aux.Age, &value)
+	if err != nil {
+		return ErrAgeNotInteger
+	}
+	e.Age, err = ParseAge(value)
+	return err
+}
+
+// The model of the Entry column available to the clients for the
+// filtering and sorting. The first operator is applied by the "col" and
+// "data" filter, all of them are allowed in the structured filter.
+type Column struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Filterable bool     `json:"filterable"`
+	Sortable   bool     `json:"sortable"`
+	Operators  []string `json:"operators"`
+}
+
+// The whitelist of the Entry columns enforced by the handlers.
+var Columns = []Column{
+	{Name: "id", Type: "integer", Sortable: true, Operators: []string{}},
+	{
+		Name:       "name",
+		Type:       "string",
+		Filterable: true,
+		Sortable:   true,
+		Operators:  []string{"like", "eq", "ne"},
+	},
+	{
+		Name:       "surname",
+		Type:       "string",
+		Filterable: true,
+		Sortable:   true,
+		Operators:  []string{"like", "eq", "ne"},
+	},
+	{
+		Name:       "patronymic",
+		Type:       "string",
+		Filterable: true,
+		Sortable:   true,
+		Operators:  []string{"like", "eq", "ne"},
+	},
+	{
+		Name:       "age",
+		Type:       "integer",
+		Filterable: true,
+		Sortable:   true,
+		Operators:  []string{"eq", "ne", "gt", "gte", "lt", "lte"},
+	},
+	{
+		Name:       "gender",
+		Type:       "string",
+		Filterable: true,
+		Sortable:   true,
+		Operators:  []string{"like", "eq", "ne"},
+	},
+	{
+		Name:       "nationality",
+		Type:       "string",
+		Filterable: true,
+		Sortable:   true,
+		Operators:  []string{"like", "eq", "ne"},
+	},
+	{
+		Name:       "source",
+		Type:       "string",
+		Filterable: true,
+		Sortable:   true,
+		Operators:  []string{"like", "eq", "ne"},
+	},
+	{Name: "confidence", Type: "number", Sortable: true, Operators: []string{}},
+}
+
+// The sort expressions of the columns derived from the other tables.
+// The confidence is the lowest provider probability of the gender and
+// the nationality, the entries without it are sorted last.
+var sortExprs = map[string]string{
+	"confidence": `(SELECT MIN(probability) FROM provenances
+		WHERE provenances.entry_id = entries.id
+		AND provenances.field IN ('gender', 'nationality')
+		AND provenances.provider NOT IN ('supplied', 'patronymic'))`,
+}
+
+// The function returns the whitelisted filterable column regardless of
+// the case, otherwise returns false.
+func FilterColumn(col string) (Column, bool) {
+	col = strings.ToLower(col)
+	for _, v := range Columns {
+		if v.Name == col && v.Filterable {
+			return v, true
+		}
+	}
+	return Column{}, false
+}
+
+// The function returns the order clause by the whitelisted sortable
+// column or its sort expression. A leading "-" sets the descending
+// order, otherwise returns an error.
+func SortOrder(sort string) (string, error) {
+	col := strings.ToLower(strings.TrimPrefix(sort, "-"))
+	for _, v := range Columns {
+		if v.Name != col || !v.Sortable {
+			continue
+		}
+		if expr, ok := sortExprs[col]; ok {
+			if strings.HasPrefix(sort, "-") {
+				return expr + " DESC NULLS LAST", nil
+			}
+			return expr + " ASC NULLS LAST", nil
+		}
+		if strings.HasPrefix(sort, "-") {
+			return col + " desc", nil
+		}
+		return col, nil
+	}
+	return "", fmt.Errorf(`column "%s" is not sortable`, col)
+}
+
+// The method returns the filtering condition of the column with its
+// argument according to the first column operator, otherwise returns an
+// error.
+func (c Column) Where(data string) (string, interface{}, error) {
+	if c.Type == "integer" {
+		if _, err := strconv.Atoi(data); err != nil {
+			return "", nil, fmt.Errorf(`column "%s" expects an integer`, c.Name)
+		}
+	}
+	switch c.Operators[0] {
+	case "like":
+		return c.Name + " LIKE ?", "%" + data + "%", nil
+	default:
+		return c.Name + " = ?", data, nil
+	}
+}
+
+// The function checks the values of the partial Entry update. Returns
+// the columns map for saving, otherwise returns an error.
+func ValidUpdates(set map[string]interface{}) (map[string]interface{}, error) {
+	updates := make(map[string]interface{})
+	var errContent []string
+	for col, value := range set {
+		var cause string
+		switch col {
+		case "name", "surname":
+			str, _ := value.(string)
+			str = normalize(str)
+			cause = checkName(col, str)
+			updates[col] = str
+		case "patronymic":
+			str, _ := value.(string)
+			str = normalize(str)
+			updates[col] = str
+		case "age":
+			number, _ := value.(float64)
+			age, err := ParseAge(number)
+			if err != nil {
+				cause = err.Error()
+				break
+			}
+			cause = checkAge(int(age))
+			updates[col] = age
+		case "gender":
+			str, _ := value.(string)
+			str = normalize(str)
+			cause = checkGender(str)
+			updates[col] = str
+		case "nationality":
+			str, _ := value.(string)
+			str = normalizeCountry(str)
+			cause = checkNationality(str)
+			updates[col] = str
+		case "verified":
+			flag, ok := value.(bool)
+			if !ok {
+				cause = "verified must be a boolean"
+				break
+			}
+			updates[col] = flag
+		default:
+			cause = fmt.Sprintf("%s cannot be updated", col)
+		}
+		if cause != "" {
+			errContent = append(errContent, cause)
+		}
+	}
+	if len(errContent) > 0 {
+		sort.Strings(errContent)
+		return nil, errors.New(strings.Join(errContent, ", "))
+	}
+	if len(updates) == 0 {
+		return nil, errors.New("nothing to update")
+	}
+	return updates, nil
+}
+
+// The function checks the name or surname value. Returns the cause of
+// the invalidity, otherwise returns an empty string.
+func checkName(field string, value string) string {
 	switch {
-	case e.Gender == "":
-		errContent = append(errContent, "gender cannot be empty")
-	case e.Gender != "male" && e.Gender != "female":
-		errContent = append(
-			errContent, `only “male” or “female” gender is available`,
-		)
+	case value == "":
+		return field + " cannot be empty"
+	case len(value) < 2:
+		return field + " is too short"
+	case len(value) > 50:
+		return field + " is too long"
+	case !namePattern.MatchString(value):
+		return field + " contains invalid characters"
 	}
-	// Nationality
+	return ""
+}
+
+// The function checks the age value. Returns the cause of the
+// invalidity, otherwise returns an empty string.
+func checkAge(age int) string {
+	if age < 1 || age > 120 {
+		return "age contains invalid data"
+	}
+	return ""
+}
+
+// The function checks the gender value. Returns the cause of the
+// invalidity, otherwise returns an empty string.
+func checkGender(gender string) string {
 	switch {
-	case e.Nationality == "":
-		errContent = append(errContent, "nationality cannot be empty")
-	case !regexp.MustCompile(countryPattern).MatchString(e.Nationality):
-		errContent = append(
-			errContent, `nationality contains invalid data (example: RU, US)`,
-		)
+	case gender == "":
+		return "gender cannot be empty"
+	case gender != "male" && gender != "female":
+		return `only “male” or “female” gender is available`
 	}
-	if len(errContent) == 0 {
-		return nil
+	return ""
+}
+
+// The function checks the nationality value. Returns the cause of the
+// invalidity, otherwise returns an empty string.
+func checkNationality(nationality string) string {
+	switch {
+	case nationality == "":
+		return "nationality cannot be empty"
+	case !countryPattern.MatchString(nationality):
+		return `nationality contains invalid data (example: RU, US)`
 	}
-	err := strings.Join(errContent, ", ")
-	return errors.New(err)
+	return ""
 }
 
 // The method for enrich Apache Kafka messages by age, gender and
-// nationality. It fills the model Entry from API, otherwise return an
-// error.
+// nationality. It fills the missing fields of the model Entry from API
+// with the provenance of each field, otherwise return an error. The
+// already filled fields are kept without the provider requests.
+// The providers are requested in parallel, or one by one in the age,
+// gender, nationality order with ENRICH_MODE=sequential to lower the
+// burst rate. The gender inferred from the patronymic replaces the
+// provider request with ENRICH_PATRONYMIC=true. The gender and the
+// nationality below ENRICH_MIN_CONFIDENCE are left unknown. With
+// ENRICH_PROVIDER=single all fields come from one ENRICH_SINGLE_URL
+// request instead of the public providers.
 func (e *Entry) Enrich(name string) error {
+	return e.EnrichContext(context.Background(), name)
+}
+
+// The method enriches the Entry like Enrich() with the provider requests
+// canceled by the context.
+func (e *Entry) EnrichContext(ctx context.Context, name string) error {
 	f := logging.F()
-	errCh := make(chan error, 3)
-	var tasks sync.WaitGroup
-	tasks.Add(3)
-	go age(name, &e.Age, &tasks, errCh)
-	go gender(name, &e.Gender, &tasks, errCh)
-	go nationality(name, &e.Nationality, &tasks, errCh)
-	go func() {
-		tasks.Wait()
-		close(errCh)
-	}()
-	for err := range errCh {
+	name, err := enrichName(name)
+	if err != nil {
 		log.Error(f+"failed to enrich data from API: ", err)
 		return err
 	}
+	prov := make([]Provenance, 3)
+	status := make(EnrichStatus, 3)
+	errs := make(map[string]chan error, 3)
+	var tasks sync.WaitGroup
+	sequential := os.Getenv("ENRICH_MODE") == "sequential"
+	start := func(field string, task func(ch chan error)) {
+		if sequential {
+			for _, ch := range errs {
+				if len(ch) > 0 {
+					status[field] = "skipped"
+					return
+				}
+			}
+		}
+		ch := make(chan error, 1)
+		errs[field] = ch
+		tasks.Add(1)
+		if sequential {
+			task(ch)
+			return
+		}
+		go task(ch)
+	}
+	hint := countryHint(e.Nationality)
+	needed := e.Age == 0 || e.Nationality == "" ||
+		e.Gender == "" && patronymicGender(e.Patronymic) == ""
+	if base, ok := singleProvider(); ok && needed {
+		ctx = withCombined(ctx, base, name, hint)
+	}
+	if e.Age == 0 {
+		start("age", func(ch chan error) {
+			age(ctx, name, hint, &e.Age, &prov[0], &tasks, ch)
+		})
+	} else {
+		prov[0] = supplied("age", fmt.Sprint(e.Age))
+		status["age"] = "supplied"
+	}
+	inferred := patronymicGender(e.Patronymic)
+	switch {
+	case e.Gender != "":
+		prov[1] = supplied("gender", e.Gender)
+		status["gender"] = "supplied"
+	case inferred != "":
+		e.Gender = inferred
+		prov[1] = Provenance{
+			Field:     "gender",
+			Provider:  "patronymic",
+			Value:     inferred,
+			Count:     1,
+			FetchedAt: time.Now(),
+		}
+		status["gender"] = "inferred"
+	default:
+		start("gender", func(ch chan error) {
+			gender(ctx, name, hint, &e.Gender, &prov[1], &tasks, ch)
+		})
+	}
+	if e.Nationality == "" {
+		start("nationality", func(ch chan error) {
+			nationality(ctx, name, &e.Nationality, &prov[2], &tasks, ch)
+		})
+	} else {
+		prov[2] = supplied("nationality", e.Nationality)
+		status["nationality"] = "supplied"
+	}
+	tasks.Wait()
+	var first error
+	for _, field := range []string{"age", "gender", "nationality"} {
+		ch, ok := errs[field]
+		if !ok {
+			continue
+		}
+		select {
+		case err := <-ch:
+			log.Error(f+"failed to enrich data from API: ", err)
+			status[field] = "failed: " + err.Error()
+			if first == nil {
+				first = err
+			}
+		default:
+			status[field] = "ok"
+		}
+	}
+	if first != nil {
+		return &EnrichError{Status: status, Err: first}
+	}
+	e.Provenance = prov
 	return nil
 }
 
+// The enrichment status of each field: "ok", "supplied", "inferred",
+// "skipped" after the failure in the sequential mode or "failed: "
+// with the reason.
+type EnrichStatus map[string]string
+
+// The error of the enrichment with the status of each field. It
+// reads and unwraps as the first failure of the fields.
+type EnrichError struct {
+	Status EnrichStatus
+	Err    error
+}
+
+// The method returns the message of the first failure.
+func (e *EnrichError) Error() string {
+	return e.Err.Error()
+}
+
+// The method returns the first failure for errors.Is() and errors.As().
+func (e *EnrichError) Unwrap() error {
+	return e.Err
+}
+
+// The function creates the provenance of the field value supplied by
+// the source system.
+func supplied(field string, value string) Provenance {
+	return Provenance{
+		Field:     field,
+		Provider:  "supplied",
+		Value:     value,
+		Count:     1,
+		FetchedAt: time.Now(),
+	}
+}
+
+// The suffixes of the Latin and Cyrillic patronymics by the gender they
+// encode.
+var patronymicSuffixes = map[string][]string{
+	"male":   {"vich", "ich", "ogly", "uly", "вич", "ич", "оглы", "улы"},
+	"female": {"vna", "chna", "kyzy", "qizi", "вна", "чна", "кызы"},
+}
+
+// The function infers the gender from the patronymic suffix when the
+// ENRICH_PATRONYMIC environment variable is true, otherwise returns an
+// empty string to fall back to the provider.
+func patronymicGender(patronymic string) string {
+	if os.Getenv("ENRICH_PATRONYMIC") != "true" {
+		return ""
+	}
+	patronymic = strings.ToLower(strings.TrimSpace(patronymic))
+	for gender, suffixes := range patronymicSuffixes {
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(patronymic, suffix) {
+				return gender
+			}
+		}
+	}
+	return ""
+}
+
+// The function prepares the name for sending to the providers. It is
+// trimmed, transliterated to Latin with ENRICH_TRANSLIT=true and cut to
+// the maximum length, otherwise returns an error.
+func enrichName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name for enrichment is empty")
+	}
+	if os.Getenv("ENRICH_TRANSLIT") == "true" {
+		name = translit(name)
+	}
+	runes := []rune(name)
+	if enrichNameMax > 0 && len(runes) > enrichNameMax {
+		name = strings.TrimSpace(string(runes[:enrichNameMax]))
+	}
+	return name, nil
+}
+
+// The Russian Cyrillic letters in Latin for the English-oriented
+// providers.
+var translitTable = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e",
+	'ё': "e", 'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k",
+	'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r",
+	'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// The function transliterates the Russian Cyrillic letters of the name
+// to Latin keeping the capital letters, the other characters are kept
+// as is.
+func translit(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		lower := unicode.ToLower(r)
+		latin, ok := translitTable[lower]
+		switch {
+		case !ok:
+			b.WriteRune(r)
+		case lower != r && latin != "":
+			b.WriteString(strings.ToUpper(latin[:1]) + latin[1:])
+		default:
+			b.WriteString(latin)
+		}
+	}
+	return b.String()
+}
+
+// The function builds the provider request url with the escaped name
+// and the country hint if any.
+func providerURL(base string, name string, country string) string {
+	link := base + "?name=" + url.QueryEscape(name)
+	if country != "" {
+		link += "&country_id=" + url.QueryEscape(country)
+	}
+	return link
+}
+
+// The function returns the country hint of the age and gender providers
+// from the supplied nationality, otherwise from the ENRICH_COUNTRY
+// environment variable. The invalid country codes give no hint.
+func countryHint(nationality string) string {
+	hint := normalizeCountry(nationality)
+	if hint == "" {
+		hint = normalizeCountry(os.Getenv("ENRICH_COUNTRY"))
+	}
+	if !countryPattern.MatchString(hint) {
+		return ""
+	}
+	return hint
+}
+
 // Gorutin for obtaining age data based on a name.
-func age(name string, age *uint8, wg *sync.WaitGroup, ch chan error) {
+func age(
+	ctx context.Context,
+	name string,
+	country string,
+	age *uint8,
+	prov *Provenance,
+	wg *sync.WaitGroup,
+	ch chan error,
+) {
 	defer wg.Done()
-	url := fmt.Sprintf("https://api.agify.io/?name=%s", name)
 	var reqData map[string]interface{}
-	err := apiReq(url, &reqData)
+	err := providerReq(ctx, AgifyURL, name, country, "age", &reqData)
 	if err != nil {
 		ch <- err
+		return
 	}
 	target, ok := reqData["age"].(float64) // int float64
 	if !ok {
 		ch <- errors.New("age data not found")
+		return
+	}
+	// The bounds are configurable, so the cast is guarded separately
+	// from wrapping the value around.
+	if target < 0 || target > math.MaxUint8 {
+		ch <- fmt.Errorf(
+			"%w: age %v from %s does not fit 0-%d",
+			ErrImplausible,
+			target,
+			providerName(ctx, "agify.io"),
+			math.MaxUint8,
+		)
+		return
+	}
+	if target != math.Trunc(target) ||
+		target < float64(enrichAgeMin) ||
+		target > float64(enrichAgeMax) {
+		ch <- fmt.Errorf(
+			"%w: age %v from %s is out of %d-%d",
+			ErrImplausible,
+			target,
+			providerName(ctx, "agify.io"),
+			enrichAgeMin,
+			enrichAgeMax,
+		)
+		return
 	}
 	*age = uint8(target)
+	count, _ := reqData["count"].(float64)
+	*prov = Provenance{
+		Field:     "age",
+		Provider:  providerName(ctx, "agify.io"),
+		Value:     fmt.Sprint(target),
+		Count:     int(count),
+		FetchedAt: time.Now(),
+	}
 }
 
 // Gorutin for obtaining gender data based on a name.
-func gender(name string, gender *string, wg *sync.WaitGroup, ch chan error) {
+func gender(
+	ctx context.Context,
+	name string,
+	country string,
+	gender *string,
+	prov *Provenance,
+	wg *sync.WaitGroup,
+	ch chan error,
+) {
 	defer wg.Done()
-	url := fmt.Sprintf("https://api.genderize.io/?name=%s", name)
 	var reqData map[string]interface{}
-	err := apiReq(url, &reqData)
+	err := providerReq(ctx, GenderizeURL, name, country, "gender", &reqData)
 	if err != nil {
 		ch <- err
+		return
 	}
 	target, ok := reqData["gender"].(string)
 	if !ok {
 		ch <- errors.New("gender data not found")
+		return
 	}
 	//time.Sleep(3 * time.Second)
-	*gender = target
+	count, _ := reqData["count"].(float64)
+	probability, _ := reqData["probability"].(float64)
+	*prov = Provenance{
+		Field:       "gender",
+		Provider:    providerName(ctx, "genderize.io"),
+		Value:       target,
+		Probability: probability,
+		Count:       int(count),
+		FetchedAt:   time.Now(),
+	}
+	if err := confident(prov); err != nil {
+		ch <- err
+		return
+	}
+	*gender = prov.Value
 }
 
 // Gorutin for obtaining nationality data based on a name.
 func nationality(
-	name string, nation *string, wg *sync.WaitGroup, ch chan error,
+	ctx context.Context,
+	name string,
+	nation *string,
+	prov *Provenance,
+	wg *sync.WaitGroup,
+	ch chan error,
 ) {
 	defer wg.Done()
-	url := fmt.Sprintf("https://api.nationalize.io/?name=%s", name)
 	var reqData map[string]interface{}
-	err := apiReq(url, &reqData)
+	err := providerReq(ctx, NationalizeURL, name, "", "country", &reqData)
 	if err != nil {
 		ch <- err
+		return
 	}
 	countryList, ok := reqData["country"].([]interface{})
 	if !ok || len(countryList) == 0 {
 		ch <- errors.New("country data not found")
+		return
 	}
-	firstCountry, ok := countryList[0].(map[string]interface{})
-	if !ok {
-		ch <- errors.New("invalid country data")
-	}
-	countryID, ok := firstCountry["country_id"].(string)
-	if !ok {
-		ch <- errors.New("country ID not found")
+	candidates, err := countryCandidates(countryList)
+	if err != nil {
+		ch <- err
+		return
 	}
 	//time.Sleep(3 * time.Second)
-	*nation = countryID
+	count, _ := reqData["count"].(float64)
+	*prov = Provenance{
+		Field:       "nationality",
+		Provider:    providerName(ctx, "nationalize.io"),
+		Value:       candidates[0].CountryID,
+		Probability: candidates[0].Probability,
+		Count:       int(count),
+		FetchedAt:   time.Now(),
+		Candidates:  candidates,
+	}
+	if err := confident(prov); err != nil {
+		ch <- err
+		return
+	}
+	*nation = prov.Value
+}
+
+// The function returns the country candidates of the nationality
+// provider ordered by the probability descending and capped to the top
+// ENRICH_NATIONALITY_MAX, otherwise returns an error.
+func countryCandidates(countryList []interface{}) ([]Candidate, error) {
+	candidates := make([]Candidate, 0, len(countryList))
+	for _, item := range countryList {
+		country, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid country data")
+		}
+		countryID, ok := country["country_id"].(string)
+		if !ok {
+			return nil, errors.New("country ID not found")
+		}
+		probability, _ := country["probability"].(float64)
+		candidates = append(candidates, Candidate{countryID, probability})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Probability > candidates[j].Probability
+	})
+	if len(candidates) > enrichNations {
+		candidates = candidates[:enrichNations]
+	}
+	return candidates, nil
+}
+
+// The function checks the provider probability against the
+// MinConfidence threshold, 0 disables the checking. The value
+// below it is cleared to keep the field unknown with the probability
+// left in the provenance, or rejected with ENRICH_LOW_CONFIDENCE=fail.
+func confident(prov *Provenance) error {
+	min := MinConfidence
+	if prov.Probability >= min {
+		return nil
+	}
+	if os.Getenv("ENRICH_LOW_CONFIDENCE") == "fail" {
+		return fmt.Errorf(
+			"%w: %s %q from %s has probability %v below %v",
+			ErrImplausible,
+			prov.Field,
+			prov.Value,
+			prov.Provider,
+			prov.Probability,
+			min,
+		)
+	}
+	log.Debugf(
+		"%s %q from %s is unknown, probability %v below %v",
+		prov.Field,
+		prov.Value,
+		prov.Provider,
+		prov.Probability,
+		min,
+	)
+	prov.Value = ""
+	return nil
 }
 
 // The function of processing the request to the specified url. Fills
-// out data map from the response body, otherwise returns an error.
-func apiReq(url string, reqData *map[string]interface{}) error {
-	response, err := http.Get(url)
+// out data map from the cache or the response body, otherwise returns
+// an error. Responses without the target field are cached for a
+// shorter time to retry the unknown names eventually. The quota headers
+// of the response are tracked and the request is delayed while the
+// quota is low. The connections are reused by the shared client.
+func apiReq(
+	ctx context.Context,
+	url string,
+	field string,
+	reqData *map[string]interface{},
+) error {
+	if data, ok := cache.get(url); ok {
+		*reqData = data
+		return nil
+	}
+	if err := throttle(ctx, url); err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
-	defer response.Body.Close()
+	response, err := enrichClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer closeBody(response.Body)
+	trackQuota(url, response.Header)
 	err = json.NewDecoder(response.Body).Decode(&reqData)
 	if err != nil {
 		return err
 	}
+	if response.StatusCode != http.StatusOK {
+		return nil
+	}
+	cache.set(url, *reqData, cacheTTL(*reqData, field))
 	return nil
 }
+
+// The function returns the cache time of the provider response data,
+// shorter for the data without the target field.
+func cacheTTL(data map[string]interface{}, field string) time.Duration {
+	switch target := data[field].(type) {
+	case nil:
+		return enrichNegTTL
+	case []interface{}:
+		if len(target) == 0 {
+			return enrichNegTTL
+		}
+	}
+	return enrichTTL
+}
+
+// The in-memory cache of the enrichment provider responses limited to
+// the max entries, the least recently used ones are evicted first.
+type enrichCache struct {
+	mu    sync.Mutex
+	max   int
+	items map[string]*list.Element
+	order *list.List
+}
+
+type cacheItem struct {
+	url     string
+	data    map[string]interface{}
+	expires time.Time
+}
+
+// The function creates the enrichment cache of up to max entries.
+func newEnrichCache(max int) *enrichCache {
+	return &enrichCache{
+		max:   max,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// The method returns the unexpired response data by the request url.
+func (c *enrichCache) get(url string) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.items[url]
+	if !ok {
+		return nil, false
+	}
+	item := element.Value.(*cacheItem)
+	if time.Now().After(item.expires) {
+		c.order.Remove(element)
+		delete(c.items, url)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return item.data, true
+}
+
+// The method saves the response data by the request url for the ttl and
+// evicts the least recently used entries over the limit.
+func (c *enrichCache) set(
+	url string,
+	data map[string]interface{},
+	ttl time.Duration,
+) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item := &cacheItem{url: url, data: data, expires: time.Now().Add(ttl)}
+	if element, ok := c.items[url]; ok {
+		element.Value = item
+		c.order.MoveToFront(element)
+		return
+	}
+	c.items[url] = c.order.PushFront(item)
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheItem).url)
+	}
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The rate limit quota of the provider from its response headers.
+type Quota struct {
+	Provider  string    `json:"provider"`
+	URL       string    `json:"url"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var quotas = struct {
+	mu    sync.Mutex
+	items map[string]Quota
+}{items: make(map[string]Quota)}
+
+// The function returns the base url and the name of the provider by
+// the request url, otherwise the url without the query.
+func providerOf(link string) (string, string) {
+	for _, provider := range []struct{ base, name string }{
+		{AgifyURL, "agify.io"},
+		{GenderizeURL, "genderize.io"},
+		{NationalizeURL, "nationalize.io"},
+	} {
+		if strings.HasPrefix(link, provider.base) {
+			return provider.base, provider.name
+		}
+	}
+	base, _, _ := strings.Cut(link, "?")
+	return base, base
+}
+
+// The function saves the quota of the provider from the X-Rate-Limit
+// headers of its response. The reset is given in seconds. The responses
+// without the remaining quota are ignored.
+func trackQuota(link string, header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-Rate-Limit-Remaining"))
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(header.Get("X-Rate-Limit-Limit"))
+	reset := header.Get("X-Rate-Limit-Reset")
+	if reset == "" {
+		reset = header.Get("X-Rate-Reset")
+	}
+	now := time.Now()
+	base, name := providerOf(link)
+	quota := Quota{
+		Provider:  name,
+		URL:       base,
+		Limit:     limit,
+		Remaining: remaining,
+		UpdatedAt: now,
+	}
+	if seconds, err := strconv.Atoi(reset); err == nil {
+		quota.ResetAt = now.Add(time.Duration(seconds) * time.Second)
+	}
+	quotas.mu.Lock()
+	quotas.items[base] = quota
+	quotas.mu.Unlock()
+}
+
+// The function returns the last known quotas of the providers by their
+// urls.
+func Quotas() []Quota {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+	list := make([]Quota, 0, len(quotas.items))
+	for _, quota := range quotas.items {
+		list = append(list, quota)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].URL < list[j].URL
+	})
+	return list
+}
+
+// The function delays the provider request by ENRICH_QUOTA_DELAY while
+// its remaining quota is at or below ENRICH_QUOTA_LOW before the reset,
+// so the providers are not hammered into the throttling. Returns the
+// context error if the request is canceled while waiting.
+func throttle(ctx context.Context, link string) error {
+	low := integer("ENRICH_QUOTA_LOW", 10)
+	quotas.mu.Lock()
+	base, _ := providerOf(link)
+	quota, ok := quotas.items[base]
+	quotas.mu.Unlock()
+	if !ok || quota.Remaining > low || time.Now().After(quota.ResetAt) {
+		return nil
+	}
+	delay := duration("ENRICH_QUOTA_DELAY", time.Second)
+	if delay <= 0 {
+		return nil
+	}
+	log.Debugf(
+		"%s quota is low, %d remaining, request delayed by %v",
+		quota.Provider,
+		quota.Remaining,
+		delay,
+	)
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
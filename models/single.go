@@ -0,0 +1,57 @@
+package models
+
+import (
+	"context"
+	"net/url"
+	"os"
+)
+
+// The context key of the combined provider response shared by the field
+// requests of one enrichment.
+type combinedKey struct{}
+
+// The response of the single provider with its request error and the
+// provider name for the provenance.
+type combined struct {
+	data     map[string]interface{}
+	err      error
+	provider string
+}
+
+// The function returns the url of the single private provider from the
+// ENRICH_SINGLE_URL if ENRICH_PROVIDER is "single", otherwise false and
+// the public providers are used.
+func singleProvider() (string, bool) {
+	if os.Getenv("ENRICH_PROVIDER") != "single" {
+		return "", false
+	}
+	return os.Getenv("ENRICH_SINGLE_URL"), true
+}
+
+// The function requests the single provider once by the name and the
+// country hint and returns the context sharing its response or error
+// with the field requests. The response combines the age, gender,
+// probability, count and country fields of the public providers.
+func withCombined(
+	ctx context.Context,
+	base string,
+	name string,
+	country string,
+) context.Context {
+	shared := combined{provider: base}
+	if parsed, err := url.Parse(base); err == nil && parsed.Host != "" {
+		shared.provider = parsed.Host
+	}
+	link := providerURL(base, name, country)
+	shared.err = apiReq(ctx, link, "age", &shared.data)
+	return context.WithValue(ctx, combinedKey{}, shared)
+}
+
+// The function returns the name of the field provider for the
+// provenance, the single provider in the combined mode.
+func providerName(ctx context.Context, public string) string {
+	if shared, ok := ctx.Value(combinedKey{}).(combined); ok {
+		return shared.provider
+	}
+	return public
+}